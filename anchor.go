@@ -0,0 +1,254 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// A node can be read in parts, `gaia get` takes
+//   id#install          the section of an anchor
+//   id#install/linux    a nested section, the path of its parents
+//   id#setup,run        several sections one after another
+//   id:10-20            lines 10 to 20, id:10 and id:10- work as well
+//   id#install:2-4      lines of a section
+// Anchors come from
+//   ## Install          markdown headings, in prose only, nested by level
+//   # region: deps      named regions up to "# endregion", any comment
+//                       style, "//#region deps" works too
+//   // #setup           comment anchors, up to the next one or "// #end"
+//   #install text       the original tag lines, up to the next line
+//                       starting with #
+// `gaia toc id` lists them.
+const (
+    SECTION_HEADING = "heading"
+    SECTION_REGION = "region"
+    SECTION_COMMENT = "comment"
+    SECTION_TAG = "tag"
+)
+
+// Section is an addressable part of a node's content, Start and End are
+// 1 based content lines, inclusive, without the marker lines.
+type Section struct {
+    Path string
+    Kind string
+    Title string
+    Level int
+    Start int
+    End int
+}
+
+var (
+    sectionHeadingRegexp = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+    fenceRegexp = regexp.MustCompile("^\\s*(```|~~~)")
+    regionRegexp = regexp.MustCompile(`^\s*(?://|#|--|;|<!--)\s*#?region:?\s+([\w.-]+)`)
+    endRegionRegexp = regexp.MustCompile(`^\s*(?://|#|--|;|<!--)\s*#?endregion\b`)
+    commentAnchorRegexp = regexp.MustCompile(`^\s*(?://|#|--|;)\s*#([\w.-]+)\s*(.*)$`)
+    lineRangeRegexp = regexp.MustCompile(`^(\d+)(-(\d*))?$`)
+    slugRegexp = regexp.MustCompile(`[^a-z0-9_.]+`)
+)
+
+// headingSlug makes a heading addressable: "Install on Linux!" becomes
+// install-on-linux.
+func headingSlug(title string) string {
+    return strings.Trim(slugRegexp.ReplaceAllString(strings.ToLower(title), "-"), "-")
+}
+
+// isTagAnchor matches the original "#name text" lines.
+func isTagAnchor(line string) (string, bool) {
+    fields := strings.Fields(line)
+    if len(fields) < 2 || len(fields[0]) < 2 || fields[0][0] != '#' || fields[0][1] == '#' || fields[0][1] == '!' {
+        return "", false
+    }
+    return fields[0][1:], true
+}
+
+// parseSections finds the anchors of content, headings only count when
+// the content is prose.
+func parseSections(content string, prose bool) []Section {
+    lines := strings.Split(content, "\n")
+    sections := []Section{}
+    headings := []int{} // open heading sections, outermost first
+    regions := []int{}
+    comment, tag := -1, -1
+    inFence := false
+    for n, line := range lines {
+        lineNum := n + 1
+        trimmed := strings.TrimSpace(line)
+        if prose && fenceRegexp.MatchString(line) {
+            inFence = !inFence
+            continue
+        }
+        if inFence {
+            continue
+        }
+        if tag >= 0 && strings.HasPrefix(trimmed, "#") {
+            sections[tag].End = lineNum - 1
+            tag = -1
+        }
+
+        if m := regionRegexp.FindStringSubmatch(line); m != nil {
+            path := m[1]
+            if len(regions) > 0 {
+                path = sections[regions[len(regions) - 1]].Path + "/" + path
+            }
+            regions = append(regions, len(sections))
+            sections = append(sections, Section{path, SECTION_REGION, m[1], len(regions), lineNum + 1, len(lines)})
+            continue
+        }
+        if endRegionRegexp.MatchString(line) {
+            if len(regions) > 0 {
+                sections[regions[len(regions) - 1]].End = lineNum - 1
+                regions = regions[:len(regions) - 1]
+            }
+            continue
+        }
+
+        if m := commentAnchorRegexp.FindStringSubmatch(line); m != nil && !(prose && strings.HasPrefix(trimmed, "#")) {
+            if comment >= 0 {
+                sections[comment].End = lineNum - 1
+                comment = -1
+            }
+            if m[1] != "end" {
+                comment = len(sections)
+                sections = append(sections, Section{m[1], SECTION_COMMENT, strings.TrimSpace(m[2]), 1, lineNum + 1, len(lines)})
+            }
+            continue
+        }
+
+        if m := sectionHeadingRegexp.FindStringSubmatch(trimmed); prose && m != nil && strings.HasPrefix(line, "#") {
+            level := len(m[1])
+            for len(headings) > 0 && sections[headings[len(headings) - 1]].Level >= level {
+                sections[headings[len(headings) - 1]].End = lineNum - 1
+                headings = headings[:len(headings) - 1]
+            }
+            path := headingSlug(m[2])
+            if len(headings) > 0 {
+                path = sections[headings[len(headings) - 1]].Path + "/" + path
+            }
+            headings = append(headings, len(sections))
+            sections = append(sections, Section{path, SECTION_HEADING, m[2], level, lineNum + 1, len(lines)})
+            continue
+        }
+
+        if name, ok := isTagAnchor(trimmed); ok {
+            tag = len(sections)
+            sections = append(sections, Section{name, SECTION_TAG, strings.TrimSpace(strings.TrimPrefix(trimmed, "#" + name)), 1, lineNum + 1, len(lines)})
+        }
+    }
+    return sections
+}
+
+// findSection resolves an anchor: the exact path first, then a path
+// ending with it, so #linux finds install/linux.
+func findSection(sections []Section, anchor string) (Section, bool) {
+    anchor = strings.Trim(anchor, "/")
+    for _, s := range sections {
+        if s.Path == anchor {
+            return s, true
+        }
+    }
+    slug := headingSlug(anchor)
+    for _, s := range sections {
+        if s.Kind == SECTION_HEADING && (s.Path == slug || strings.HasSuffix(s.Path, "/" + slug)) {
+            return s, true
+        }
+        if s.Kind != SECTION_HEADING && strings.HasSuffix(s.Path, "/" + anchor) {
+            return s, true
+        }
+    }
+    return Section{}, false
+}
+
+// splitAddress splits "id#anchor:10-20" into its parts, each may be empty
+// but the id.
+func splitAddress(address string) (string, string, string) {
+    id, lineRange := address, ""
+    if i := strings.LastIndex(address, ":"); i > 0 && lineRangeRegexp.MatchString(address[i + 1:]) {
+        id, lineRange = address[:i], address[i + 1:]
+    }
+    anchor := ""
+    if i := strings.Index(id, "#"); i > 0 {
+        id, anchor = id[:i], id[i + 1:]
+    }
+    return id, anchor, lineRange
+}
+
+// parseLineRange reads 10-20, 10 or 10- for content of n lines.
+func parseLineRange(lineRange string, n int) (int, int, error) {
+    m := lineRangeRegexp.FindStringSubmatch(lineRange)
+    if m == nil {
+        return 0, 0, newCodedError(ERR_INVALID, "invalid line range: " + lineRange)
+    }
+    start, _ := strconv.Atoi(m[1])
+    end := start
+    if m[2] != "" {
+        end = n
+        if m[3] != "" {
+            end, _ = strconv.Atoi(m[3])
+        }
+    }
+    if start < 1 || end < start || start > n {
+        return 0, 0, newCodedError(ERR_INVALID, fmt.Sprintf("line range %s is outside of 1-%d", lineRange, n))
+    }
+    if end > n {
+        end = n
+    }
+    return start, end, nil
+}
+
+// addressContent returns the part of content an anchor and a line range
+// select, both may be empty.
+func addressContent(content string, prose bool, anchor string, lineRange string) (string, error) {
+    lines := strings.Split(content, "\n")
+    if anchor != "" {
+        sections := parseSections(content, prose)
+        parts := []string{}
+        for _, name := range strings.Split(anchor, ",") {
+            name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+            s, ok := findSection(sections, name)
+            if !ok {
+                return "", newCodedError(ERR_NOT_FOUND, "anchor #" + name + " not found, see gaia toc")
+            }
+            if s.End >= s.Start {
+                parts = append(parts, strings.Join(lines[s.Start - 1:s.End], "\n"))
+            }
+        }
+        content = strings.Join(parts, "\n")
+        lines = strings.Split(content, "\n")
+    }
+
+    if lineRange != "" {
+        start, end, err := parseLineRange(lineRange, len(lines))
+        if err != nil {
+            return "", err
+        }
+        content = strings.Join(lines[start - 1:end], "\n")
+    }
+    return content, nil
+}
+
+func isProse(node Node) bool {
+    return nodeLanguage(node) == ""
+}
+
+// listAnchors returns the addresses of content in order, e.g. #install/linux.
+func listAnchors(content string, prose bool) []string {
+    anchors := []string{}
+    for _, s := range parseSections(content, prose) {
+        if !ArrContains(anchors, "#" + s.Path) {
+            anchors = append(anchors, "#" + s.Path)
+        }
+    }
+    return anchors
+}
+
+// anchorLines maps the 0 based index of each marker line to its anchor.
+func anchorLines(content string, prose bool) map[int]string {
+    res := make(map[int]string)
+    for _, s := range parseSections(content, prose) {
+        res[s.Start - 2] = "#" + s.Path
+    }
+    return res
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// A bundle (.gaia) is a zip of
+//   manifest.json              BundleManifest, with the sha256 of every
+//                              other entry
+//   nodes.json                 the nodes with their original ids, links
+//                              only point inside the bundle
+//   aliases.json               aliases used by the nodes' names and tags
+//   attachments/<id>/<file>    attachment files, the node lists entry names
+//   signature.json             optional, ed25519 over manifest.json
+// Import gives the nodes new local ids and rewrites their links.
+const (
+    bundleFormat = "gaia-bundle"
+    bundleVersion = 1
+    bundleManifestEntry = "manifest.json"
+    bundleNodesEntry = "nodes.json"
+    bundleAliasesEntry = "aliases.json"
+    bundleSignatureEntry = "signature.json"
+    bundleAttachmentsDir = "attachments/"
+    maxBundleEntrySize = 64 << 20
+)
+
+var bundleKeyFile = "bundle.key"
+var attachmentsDir = "attachments/"
+
+// BundleOptions of `gaia bundle`, KeyFile, Author and TrustedKeys come
+// from the config.
+type BundleOptions struct {
+    Ids []string
+    Tag string
+    Unlock bool // include secret nodes, decrypted
+    Output string
+    Sign bool
+    Verify bool
+    OnConflict string
+    KeyFile string
+    Author string
+    TrustedKeys map[string]string // name -> base64 public key
+}
+
+type BundleManifest struct {
+    Format string
+    Version int
+    Created time.Time
+    Author string
+    Nodes int
+    Files map[string]string // entry -> sha256 hex
+}
+
+type BundleSignature struct {
+    PublicKey string // base64
+    Signature string // base64, of the manifest.json bytes
+}
+
+type Bundle struct {
+    Manifest BundleManifest
+    Nodes []Node
+    Aliases map[string]string
+    Attachments map[string][]byte // entry -> content
+    Signer ed25519.PublicKey // nil when unsigned
+}
+
+func sha256Hex(bs []byte) string {
+    sum := sha256.Sum256(bs)
+    return hex.EncodeToString(sum[:])
+}
+
+// keyFingerprint is how people compare keys, the public key is in config.
+func keyFingerprint(pub ed25519.PublicKey) string {
+    return sha256Hex(pub)[:16]
+}
+
+// bundleAliases keeps the aliases whose keyword or target is a name part
+// or tag of nodes.
+func bundleAliases(nodes []Node, aliasMap map[string]string) map[string]string {
+    words := make(map[string]bool)
+    for _, node := range nodes {
+        for _, part := range strings.Split(node.Name, "-") {
+            words[part] = true
+        }
+        for _, tag := range splitList(node.Tags) {
+            words[strings.ToLower(tag)] = true
+        }
+    }
+    res := make(map[string]string)
+    for from, to := range aliasMap {
+        if words[from] || words[to] {
+            res[from] = to
+        }
+    }
+    return res
+}
+
+// writeBundle packs nodes, links to nodes outside of them are dropped.
+func writeBundle(file string, nodes []Node, aliases map[string]string, author string, key ed25519.PrivateKey) error {
+    ids := make(map[string]bool)
+    for _, node := range nodes {
+        ids[node.Id] = true
+    }
+
+    entries := make(map[string][]byte)
+    packed := []Node{}
+    for _, node := range nodes {
+        links := []string{}
+        for _, link := range splitList(node.Links) {
+            if ids[link] {
+                links = append(links, link)
+            }
+        }
+        node.Links = strings.Join(links, ",")
+
+        attachments := []string{}
+        for i, path := range node.Attachments {
+            bs, err := ioutil.ReadFile(path)
+            if err != nil {
+                return errors.New("can not read attachment of " + node.Id + ": " + err.Error())
+            }
+            entry := bundleAttachmentsDir + node.Id + "/" + strconv.Itoa(i) + "-" + filepath.Base(path)
+            entries[entry] = bs
+            attachments = append(attachments, entry)
+        }
+        node.Attachments = attachments
+        packed = append(packed, node)
+    }
+
+    var err error
+    if entries[bundleNodesEntry], err = json.MarshalIndent(packed, "", "  "); err != nil {
+        return err
+    }
+    if entries[bundleAliasesEntry], err = json.MarshalIndent(aliases, "", "  "); err != nil {
+        return err
+    }
+
+    manifest := BundleManifest{bundleFormat, bundleVersion, time.Now().UTC(), author, len(packed), map[string]string{}}
+    names := []string{}
+    for name, bs := range entries {
+        manifest.Files[name] = sha256Hex(bs)
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    manifestBs, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    write := func(name string, bs []byte) error {
+        w, err := zw.Create(name)
+        if err == nil {
+            _, err = w.Write(bs)
+        }
+        return err
+    }
+    if err := write(bundleManifestEntry, manifestBs); err != nil {
+        return err
+    }
+    for _, name := range names {
+        if err := write(name, entries[name]); err != nil {
+            return err
+        }
+    }
+    if key != nil {
+        sig := BundleSignature{
+            base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+            base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestBs)),
+        }
+        sigBs, _ := json.MarshalIndent(sig, "", "  ")
+        if err := write(bundleSignatureEntry, sigBs); err != nil {
+            return err
+        }
+    }
+    if err := zw.Close(); err != nil {
+        return err
+    }
+    return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// readBundle checks every entry against the manifest and the manifest
+// against the signature, so a signed bundle is verified as a whole.
+func readBundle(file string) (*Bundle, error) {
+    zr, err := zip.OpenReader(file)
+    if err != nil {
+        return nil, newCodedError(ERR_INVALID, "not a bundle: " + err.Error())
+    }
+    defer zr.Close()
+
+    entries := make(map[string][]byte)
+    for _, f := range zr.File {
+        if f.UncompressedSize64 > maxBundleEntrySize {
+            return nil, newCodedError(ERR_INVALID, "bundle entry too large: " + f.Name)
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, err
+        }
+        bs, err := ioutil.ReadAll(io.LimitReader(rc, maxBundleEntrySize))
+        rc.Close()
+        if err != nil {
+            return nil, err
+        }
+        entries[f.Name] = bs
+    }
+
+    invalid := func(msg string) error {
+        return newCodedError(ERR_INVALID, "invalid bundle " + file + ": " + msg)
+    }
+    bundle := &Bundle{Aliases: map[string]string{}, Attachments: map[string][]byte{}}
+    manifestBs, ok := entries[bundleManifestEntry]
+    if !ok {
+        return nil, invalid("no " + bundleManifestEntry)
+    }
+    if err := json.Unmarshal(manifestBs, &bundle.Manifest); err != nil {
+        return nil, invalid(err.Error())
+    }
+    if bundle.Manifest.Format != bundleFormat {
+        return nil, invalid("unknown format " + bundle.Manifest.Format)
+    }
+    if bundle.Manifest.Version > bundleVersion {
+        return nil, invalid("made by a newer gaia, version " + strconv.Itoa(bundle.Manifest.Version))
+    }
+
+    for name, bs := range entries {
+        if name == bundleManifestEntry || name == bundleSignatureEntry {
+            continue
+        }
+        if sum, listed := bundle.Manifest.Files[name]; !listed || sum != sha256Hex(bs) {
+            return nil, invalid(name + " does not match the manifest")
+        }
+    }
+    for name := range bundle.Manifest.Files {
+        if _, exist := entries[name]; !exist {
+            return nil, invalid(name + " is missing")
+        }
+    }
+
+    if sigBs, signed := entries[bundleSignatureEntry]; signed {
+        sig := BundleSignature{}
+        if err := json.Unmarshal(sigBs, &sig); err != nil {
+            return nil, invalid(err.Error())
+        }
+        pub, err1 := base64.StdEncoding.DecodeString(sig.PublicKey)
+        signature, err2 := base64.StdEncoding.DecodeString(sig.Signature)
+        if err1 != nil || err2 != nil || len(pub) != ed25519.PublicKeySize {
+            return nil, invalid("malformed signature")
+        }
+        if !ed25519.Verify(ed25519.PublicKey(pub), manifestBs, signature) {
+            return nil, invalid("signature does not match, the bundle was changed after signing")
+        }
+        bundle.Signer = ed25519.PublicKey(pub)
+    }
+
+    if err := json.Unmarshal(entries[bundleNodesEntry], &bundle.Nodes); err != nil {
+        return nil, invalid(err.Error())
+    }
+    if err := json.Unmarshal(entries[bundleAliasesEntry], &bundle.Aliases); err != nil {
+        return nil, invalid(err.Error())
+    }
+    for name, bs := range entries {
+        if strings.HasPrefix(name, bundleAttachmentsDir) {
+            bundle.Attachments[name] = bs
+        }
+    }
+    return bundle, nil
+}
+
+// saveAttachments writes the bundle attachments of a node below
+// ~/.gaia/attachments/<id>/ and returns their paths.
+func saveAttachments(bundle *Bundle, node Node, id string) ([]string, error) {
+    paths := []string{}
+    for _, entry := range node.Attachments {
+        bs, ok := bundle.Attachments[entry]
+        if !ok {
+            return nil, errors.New("attachment missing in bundle: " + entry)
+        }
+        base := filepath.Base(entry)
+        if i := strings.Index(base, "-"); i >= 0 {
+            base = base[i + 1:]
+        }
+        path := filepath.Join(attachmentsDir, id, base)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            return nil, err
+        }
+        if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+            return nil, err
+        }
+        paths = append(paths, path)
+    }
+    return paths, nil
+}
+
+func loadBundleKey(path string) (ed25519.PrivateKey, error) {
+    bs, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil, newCodedError(ERR_NOT_FOUND, "no signing key, create one with: gaia bundle keygen")
+    }
+    if err != nil {
+        return nil, err
+    }
+    seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(bs)))
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return nil, newCodedError(ERR_INVALID, "malformed signing key in " + path)
+    }
+    return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// generateBundleKey writes a new key, an existing one is kept.
+func generateBundleKey(path string) (ed25519.PublicKey, error) {
+    if _, err := os.Stat(path); err == nil {
+        return nil, newCodedError(ERR_CONFLICT, "signing key exists: " + path)
+    }
+    pub, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    seed := base64.StdEncoding.EncodeToString(key.Seed())
+    return pub, ioutil.WriteFile(path, []byte(seed + "\n"), 0600)
+}
+
+// trustedSigner names the signer by the TrustedKeys of the config.
+func trustedSigner(pub ed25519.PublicKey, trusted map[string]string) (string, bool) {
+    encoded := base64.StdEncoding.EncodeToString(pub)
+    for name, key := range trusted {
+        if strings.TrimSpace(key) == encoded {
+            return name, true
+        }
+    }
+    return "", false
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "sort"
+    "strings"
+    "time"
+)
+
+// nodes tagged card are reviewed by `gaia review`. The question is, in
+// order of preference
+//   - the question key of front matter at the top of the content, the
+//     answer key or the rest of the content is the answer
+//   - the content above a --- line, the answer below it
+//   - the description, the content is the answer
+//   - the name
+const cardTag = "card"
+
+const (
+    defaultCardEase = 2.5
+    minCardEase = 1.3
+    matureCardInterval = 21 // days
+)
+
+// CardState is the SM-2 schedule of a card, the store keeps it by node id.
+type CardState struct {
+    Ease float64
+    Interval int // days
+    Repetitions int // correct answers in a row
+    Due time.Time
+    Reviews int
+    Correct int
+    Lapses int
+    LastReviewed time.Time
+}
+
+func newCardState() CardState {
+    return CardState{Ease: defaultCardEase}
+}
+
+func (state CardState) isNew() bool {
+    return state.Reviews == 0
+}
+
+// schedule applies an answer graded 0 (blackout) to 5 (perfect), grades
+// below 3 start the card over.
+func (state CardState) schedule(grade int, now time.Time) CardState {
+    state.Reviews++
+    state.LastReviewed = now
+    if grade < 3 {
+        state.Repetitions = 0
+        state.Interval = 1
+        state.Lapses++
+    } else {
+        state.Correct++
+        state.Repetitions++
+        switch state.Repetitions {
+        case 1:
+            state.Interval = 1
+        case 2:
+            state.Interval = 6
+        default:
+            state.Interval = int(math.Round(float64(state.Interval) * state.Ease))
+        }
+    }
+
+    q := float64(5 - grade)
+    state.Ease = math.Max(minCardEase, state.Ease + 0.1 - q * (0.08 + q * 0.02))
+    state.Due = startOfDay(now).AddDate(0, 0, state.Interval)
+    return state
+}
+
+func startOfDay(t time.Time) time.Time {
+    y, m, d := t.Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func isCard(node Node) bool {
+    return hasTag(node, cardTag)
+}
+
+// cardSides splits a card into question and answer, see above.
+func cardSides(node Node) (string, string) {
+    content := strings.TrimSpace(node.Content)
+    if fmLines, body, ok := splitFrontMatter(content); ok {
+        question, answer := "", strings.TrimSpace(body)
+        for _, line := range fmLines {
+            parts := strings.SplitN(line, ":", 2)
+            if len(parts) != 2 {
+                continue
+            }
+            value, err := parseYamlScalar(strings.TrimSpace(parts[1]))
+            if err != nil {
+                continue
+            }
+            switch strings.TrimSpace(parts[0]) {
+            case "question":
+                question = value
+            case "answer":
+                answer = value
+            }
+        }
+        if question != "" {
+            return question, answer
+        }
+    }
+
+    lines := strings.Split(content, "\n")
+    for i, line := range lines {
+        if strings.TrimSpace(line) == frontMatterDelimiter {
+            return strings.TrimSpace(strings.Join(lines[:i], "\n")), strings.TrimSpace(strings.Join(lines[i + 1:], "\n"))
+        }
+    }
+    if node.Desc != "" {
+        return node.Desc, content
+    }
+    return node.Name, content
+}
+
+// dueCard is a card with its schedule, new cards are due right away.
+type dueCard struct {
+    Node Node
+    State CardState
+}
+
+// dueCards returns the cards due at now, overdue cards first, then new
+// ones in name order.
+func dueCards(nodes []Node, states map[string]CardState, now time.Time) []dueCard {
+    due := []dueCard{}
+    for _, node := range nodes {
+        state, ok := states[node.Id]
+        if !ok {
+            state = newCardState()
+        }
+        if state.isNew() || !state.Due.After(now) {
+            due = append(due, dueCard{node, state})
+        }
+    }
+    sort.SliceStable(due, func(i, j int) bool {
+        a, b := due[i], due[j]
+        if a.State.isNew() != b.State.isNew() {
+            return !a.State.isNew()
+        }
+        if !a.State.isNew() && !a.State.Due.Equal(b.State.Due) {
+            return a.State.Due.Before(b.State.Due)
+        }
+        return a.Node.Name < b.Node.Name
+    })
+    return due
+}
+
+// CardStats summarizes the cards of `gaia review --stats`, Retention is
+// the share of reviews answered with grade 3 or better.
+type CardStats struct {
+    Cards int
+    New int
+    DueToday int
+    DueWeek int
+    Learning int
+    Mature int
+    Reviews int
+    Retention float64
+}
+
+func cardStats(nodes []Node, states map[string]CardState, now time.Time) CardStats {
+    stats := CardStats{Cards: len(nodes)}
+    correct := 0
+    endOfToday := startOfDay(now).AddDate(0, 0, 1)
+    endOfWeek := startOfDay(now).AddDate(0, 0, 7)
+    for _, node := range nodes {
+        state, ok := states[node.Id]
+        if !ok || state.isNew() {
+            stats.New++
+            continue
+        }
+        if state.Due.Before(endOfToday) {
+            stats.DueToday++
+        }
+        if state.Due.Before(endOfWeek) {
+            stats.DueWeek++
+        }
+        if state.Interval >= matureCardInterval {
+            stats.Mature++
+        } else {
+            stats.Learning++
+        }
+        stats.Reviews += state.Reviews
+        correct += state.Correct
+    }
+    if stats.Reviews > 0 {
+        stats.Retention = float64(correct) / float64(stats.Reviews)
+    }
+    return stats
+}
+
+// readGrade asks until it gets 0-5, s skips the card and q ends the session.
+func readGrade(reader *bufio.Reader, out io.Writer) (string, error) {
+    for {
+        fmt.Fprint(out, "grade 0-5 (0 forgot, 3 hard, 4 good, 5 easy), s skip, q quit: ")
+        line, err := reader.ReadString('\n')
+        answer := strings.TrimSpace(line)
+        if len(answer) == 1 && strings.Contains("012345sq", answer) {
+            return answer, nil
+        }
+        if err != nil {
+            return "q", err
+        }
+    }
+}
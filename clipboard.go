@@ -0,0 +1,168 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "errors"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "runtime"
+    "strings"
+)
+
+// ClipboardProvider is the system clipboard as seen by get --copy,
+// add --from-clipboard and exec --clipboard.
+type ClipboardProvider interface {
+    Name() string
+    Copy(text string) error
+    Paste() (string, error)
+}
+
+// commandClipboard pipes through tools like xclip or wl-copy.
+type commandClipboard struct {
+    name string
+    copyCmd []string
+    pasteCmd []string
+}
+
+var commandClipboards = map[string]*commandClipboard{
+    "wl-copy": {"wl-copy", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}},
+    "xclip": {"xclip", []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+    "xsel": {"xsel", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+    "pbcopy": {"pbcopy", []string{"pbcopy"}, []string{"pbpaste"}},
+}
+
+func (cb *commandClipboard) Name() string {
+    return cb.name
+}
+
+// Copy sends stderr to a file, not a pipe: xclip and wl-copy fork a
+// daemon to serve the clipboard that keeps stderr open, and Run would wait
+// for the pipe until the clipboard changes.
+func (cb *commandClipboard) Copy(text string) error {
+    stderr, err := ioutil.TempFile("", "gaia-clipboard-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(stderr.Name())
+    defer stderr.Close()
+    cmd := exec.Command(cb.copyCmd[0], cb.copyCmd[1:]...)
+    cmd.Stdin = strings.NewReader(text)
+    cmd.Stderr = stderr
+    if err := cmd.Run(); err != nil {
+        msg, _ := ioutil.ReadFile(stderr.Name())
+        return errors.New(cb.name + ": " + strings.TrimSpace(err.Error() + " " + string(msg)))
+    }
+    return nil
+}
+
+func (cb *commandClipboard) Paste() (string, error) {
+    var stderr bytes.Buffer
+    cmd := exec.Command(cb.pasteCmd[0], cb.pasteCmd[1:]...)
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return "", errors.New(cb.pasteCmd[0] + ": " + strings.TrimSpace(err.Error() + " " + stderr.String()))
+    }
+    return string(out), nil
+}
+
+// osc52Clipboard asks the terminal to set its clipboard, which also works
+// over ssh. Terminals do not answer reads, so it can only copy.
+type osc52Clipboard struct {
+    out io.Writer
+    tmux bool
+}
+
+func (cb *osc52Clipboard) Name() string {
+    return "osc52"
+}
+
+func (cb *osc52Clipboard) Copy(text string) error {
+    seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+    if cb.tmux {
+        // tmux passes sequences wrapped in DCS through to the outer terminal.
+        seq = "\x1bPtmux;\x1b" + seq + "\x1b\\"
+    }
+    _, err := io.WriteString(cb.out, seq)
+    return err
+}
+
+func (cb *osc52Clipboard) Paste() (string, error) {
+    return "", newCodedError(ERR_UNAVAILABLE, "osc52 can only copy, install xclip, xsel or wl-clipboard to paste")
+}
+
+// fileClipboard keeps the clipboard in a file, for headless machines and
+// for tests: "Clipboard": "file:/tmp/clipboard".
+type fileClipboard struct {
+    path string
+}
+
+func (cb *fileClipboard) Name() string {
+    return "file:" + cb.path
+}
+
+func (cb *fileClipboard) Copy(text string) error {
+    return ioutil.WriteFile(cb.path, []byte(text), 0600)
+}
+
+func (cb *fileClipboard) Paste() (string, error) {
+    bs, err := ioutil.ReadFile(cb.path)
+    if os.IsNotExist(err) {
+        return "", nil
+    }
+    return string(bs), err
+}
+
+func newOsc52Clipboard(getenv func(string) string) *osc52Clipboard {
+    var out io.Writer = os.Stderr
+    if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+        out = tty
+    }
+    return &osc52Clipboard{out: out, tmux: getenv("TMUX") != ""}
+}
+
+// detectClipboard picks the configured provider, or the first tool that
+// fits the session: wayland, then X11, then macOS, then osc52 when
+// attached to a terminal over ssh.
+func detectClipboard(configured string, getenv func(string) string, lookPath func(string) (string, error)) (ClipboardProvider, error) {
+    configured = strings.TrimSpace(configured)
+    switch {
+    case strings.HasPrefix(configured, "file:"):
+        return &fileClipboard{strings.TrimPrefix(configured, "file:")}, nil
+    case configured == "osc52":
+        return newOsc52Clipboard(getenv), nil
+    case configured != "":
+        cb, ok := commandClipboards[configured]
+        if !ok {
+            return nil, newCodedError(ERR_INVALID, "unknown clipboard: " + configured + ", expect wl-copy, xclip, xsel, pbcopy, osc52 or file:<path>")
+        }
+        if _, err := lookPath(cb.copyCmd[0]); err != nil {
+            return nil, newCodedError(ERR_UNAVAILABLE, "clipboard " + configured + " is configured but not installed")
+        }
+        return cb, nil
+    }
+
+    candidates := []string{}
+    if getenv("WAYLAND_DISPLAY") != "" {
+        candidates = append(candidates, "wl-copy")
+    }
+    if getenv("DISPLAY") != "" {
+        candidates = append(candidates, "xclip", "xsel")
+    }
+    if runtime.GOOS == "darwin" {
+        candidates = append(candidates, "pbcopy")
+    }
+    for _, name := range candidates {
+        if _, err := lookPath(commandClipboards[name].copyCmd[0]); err == nil {
+            return commandClipboards[name], nil
+        }
+    }
+    if getenv("SSH_TTY") != "" || getenv("SSH_CONNECTION") != "" {
+        return newOsc52Clipboard(getenv), nil
+    }
+    return nil, newCodedError(ERR_UNAVAILABLE, "no clipboard found: install wl-clipboard, xclip or xsel, " +
+        "or set \"Clipboard\" in " + configFilePath + " to osc52 or file:<path>")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func fakeEnv(env map[string]string) func(string) string {
+    return func(key string) string {
+        return env[key]
+    }
+}
+
+func fakeLookPath(installed ...string) func(string) (string, error) {
+    return func(name string) (string, error) {
+        for _, tool := range installed {
+            if tool == name {
+                return "/usr/bin/" + name, nil
+            }
+        }
+        return "", errors.New(name + " not found")
+    }
+}
+
+func TestDetectClipboard(t *testing.T) {
+    tests := []struct {
+        desc string
+        configured string
+        env map[string]string
+        installed []string
+        want string
+        code string
+    }{
+        {"wayland first", "", map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}, []string{"wl-copy", "xclip"}, "wl-copy", ""},
+        {"x11 without xclip", "", map[string]string{"DISPLAY": ":0"}, []string{"xsel"}, "xsel", ""},
+        {"ssh falls back to osc52", "", map[string]string{"SSH_TTY": "/dev/pts/1"}, nil, "osc52", ""},
+        {"nothing found", "", map[string]string{}, nil, "", ERR_UNAVAILABLE},
+        {"configured file", "file:/tmp/cb", map[string]string{}, nil, "file:/tmp/cb", ""},
+        {"configured tool", "xclip", map[string]string{}, []string{"xclip"}, "xclip", ""},
+        {"configured tool missing", "xclip", map[string]string{"DISPLAY": ":0"}, []string{"xsel"}, "", ERR_UNAVAILABLE},
+        {"configured unknown", "clippy", map[string]string{}, nil, "", ERR_INVALID},
+    }
+    for _, test := range tests {
+        cb, err := detectClipboard(test.configured, fakeEnv(test.env), fakeLookPath(test.installed...))
+        if test.code != "" {
+            if errorCode(err) != test.code {
+                t.Errorf("%s: got error %v, want code %s", test.desc, err, test.code)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", test.desc, err)
+            continue
+        }
+        if cb.Name() != test.want {
+            t.Errorf("%s: got %s, want %s", test.desc, cb.Name(), test.want)
+        }
+    }
+}
+
+func TestOsc52Copy(t *testing.T) {
+    out := &bytes.Buffer{}
+    if err := (&osc52Clipboard{out: out}).Copy("hi"); err != nil {
+        t.Fatal(err)
+    }
+    if want := "\x1b]52;c;aGk=\a"; out.String() != want {
+        t.Errorf("got %q, want %q", out.String(), want)
+    }
+    out.Reset()
+    (&osc52Clipboard{out: out, tmux: true}).Copy("hi")
+    if want := "\x1bPtmux;\x1b\x1b]52;c;aGk=\a\x1b\\"; out.String() != want {
+        t.Errorf("tmux: got %q, want %q", out.String(), want)
+    }
+}
+
+// the fake copy tool leaves a child with stderr open, like the daemons of
+// xclip and wl-copy.
+func TestCommandClipboardCopyDaemon(t *testing.T) {
+    if _, err := exec.LookPath("sh"); err != nil {
+        t.Skip("no sh")
+    }
+    dir, err := ioutil.TempDir("", "gaia-clipboard-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    file := filepath.Join(dir, "clipboard")
+    script := filepath.Join(dir, "fake-copy")
+    content := "#!/bin/sh\ncat > " + file + "\necho serving >&2\nsleep 10 &\n"
+    if err := ioutil.WriteFile(script, []byte(content), 0700); err != nil {
+        t.Fatal(err)
+    }
+
+    cb := &commandClipboard{"fake", []string{script}, []string{"cat", file}}
+    start := time.Now()
+    if err := cb.Copy("hello"); err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed > 5 * time.Second {
+        t.Errorf("copy waited %v for the daemon", elapsed)
+    }
+    if text, err := cb.Paste(); err != nil || text != "hello" {
+        t.Errorf("paste: got %q, %v", text, err)
+    }
+}
+
+func TestCommandClipboardCopyError(t *testing.T) {
+    if _, err := exec.LookPath("sh"); err != nil {
+        t.Skip("no sh")
+    }
+    cb := &commandClipboard{"fake", []string{"sh", "-c", "echo no display >&2; exit 1"}, nil}
+    err := cb.Copy("hello")
+    if err == nil || err.Error() != "fake: exit status 1 no display" {
+        t.Errorf("got %v", err)
+    }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+)
+
+const configFileName = "config.json"
+var configFilePath = ""
+
+// Config is read from ~/.gaia/config.json, a missing file means defaults.
+type Config struct {
+    Editor string // e.g. "code --wait", overrides $VISUAL and $EDITOR
+    Author string // of new nodes, the git user.name or login name when empty
+    Clipboard string // wl-copy, xclip, xsel, pbcopy, osc52 or file:<path>, detected when empty
+    Exec ExecConfig
+    Sandbox SandboxConfig
+    Serve ServeConfig
+    Bundle BundleConfig
+    Secret SecretConfig
+    Git GitConfig // keep the nodes in a git working tree when Dir is set
+}
+
+// ExecConfig holds exec limits and the project cache cap as written by the
+// user, e.g.
+//   "Exec": { "Timeout": "30s", "Memory": "512m", "MaxOutput": "1m", "CacheSize": "2g" }
+type ExecConfig struct {
+    Timeout string
+    Memory string
+    Cpu string
+    MaxOutput string
+    NoNetwork bool
+    CacheSize string
+}
+
+func loadConfig(fpath string) (Config, error) {
+    config := Config{}
+    bs, err := ioutil.ReadFile(fpath)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return config, nil
+        }
+        return config, err
+    }
+
+    if len(bs) > 0 {
+        err = json.Unmarshal(bs, &config)
+    }
+    return config, err
+}
+
+// SandboxConfig lists extra read-only paths for `exec --sandbox`, e.g. a
+// toolchain installed below $HOME.
+type SandboxConfig struct {
+    ReadOnlyPaths []string
+}
+
+// ServeConfig holds the defaults of `gaia serve`, e.g.
+//   "Serve": { "Addr": "127.0.0.1:8080", "Token": "...", "CorsOrigins": ["*"] }
+type ServeConfig struct {
+    Addr string
+    Token string
+    CorsOrigins []string
+    ReadOnly bool
+}
+
+// BundleConfig signs and verifies bundles, e.g.
+//   "Bundle": { "Author": "ann", "TrustedKeys": { "bob": "<public key of bob>" } }
+// KeyFile defaults to ~/.gaia/bundle.key, see `gaia bundle keygen`.
+type BundleConfig struct {
+    Author string
+    KeyFile string
+    TrustedKeys map[string]string
+}
+
+// SecretConfig unlocks secret nodes without asking, e.g.
+//   "Secret": { "PassphraseFile": "/run/user/1000/gaia-passphrase" }
+type SecretConfig struct {
+    PassphraseFile string
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "errors"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+const defaultEditor = "vi"
+
+// tag -> file extension for editor syntax highlighting.
+var tagExtMap = map[string]string{
+    "js": ".js",
+    "javascript": ".js",
+    "node": ".js",
+    "nodejs": ".js",
+    "ts": ".ts",
+    "typescript": ".ts",
+    "go": ".go",
+    "golang": ".go",
+    "java": ".java",
+    "scala": ".scala",
+    "python": ".py",
+    "py": ".py",
+    "sh": ".sh",
+    "shell": ".sh",
+    "bash": ".sh",
+    "sql": ".sql",
+    "rust": ".rs",
+    "ruby": ".rb",
+    "c": ".c",
+    "cpp": ".cpp",
+    "html": ".html",
+    "css": ".css",
+    "json": ".json",
+    "yaml": ".yaml",
+    "solidity": ".sol",
+}
+
+// resolveEditor follows git: the configured editor, then $VISUAL, $EDITOR
+// and vi. The value may carry arguments, e.g. "code --wait".
+func resolveEditor(configured string) string {
+    for _, editor := range []string{configured, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+        if strings.TrimSpace(editor) != "" {
+            return strings.TrimSpace(editor)
+        }
+    }
+    return defaultEditor
+}
+
+// runEditor opens file in editor through sh, so quoting and arguments in
+// the editor setting work like they do for git.
+func runEditor(editor string, file string) error {
+    cmd := exec.Command("sh", "-c", editor + ` "$@"`, editor, file)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+
+    if err := cmd.Run(); err != nil {
+        if _, ok := err.(*exec.ExitError); ok {
+            return newCodedError(ERR_INVALID, "editor '" + editor + "' exited with " + err.Error() + ", edit aborted")
+        }
+        return errors.New("can not run editor '" + editor + "': " + err.Error())
+    }
+    return nil
+}
+
+// editFileExtension picks the temp file extension from ExecFile, then from
+// the first tag naming a language, then markdown.
+func editFileExtension(node Node) string {
+    if ext := filepath.Ext(node.ExecFile); ext != "" {
+        return ext
+    }
+    for _, tag := range splitList(node.Tags) {
+        if ext, ok := tagExtMap[strings.ToLower(tag)]; ok {
+            return ext
+        }
+    }
+    return ".md"
+}
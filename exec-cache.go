@@ -0,0 +1,154 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// exec projects are kept in execCacheDir/<hash of files>, so node_modules
+// and target/ survive between runs of the same snippet. A run holds a
+// shared flock on <dir>.lock, gc holds execCacheDir/.lock and skips the
+// projects it can not lock, so it never removes one that is running.
+var execCacheDir = "exec-cache/"
+const defaultExecCacheSize int64 = 1 << 30
+const execCacheLockFile = ".lock"
+
+type cachedProject struct {
+    Dir string
+    Size int64
+    LastUsed time.Time
+}
+
+func execProjectDir(filesMap map[string]string) string {
+    names := []string{}
+    for name := range filesMap {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    h := sha256.New()
+    for _, name := range names {
+        h.Write([]byte(name))
+        h.Write([]byte{0})
+        h.Write([]byte(filesMap[name]))
+        h.Write([]byte{0})
+    }
+    return filepath.Join(execCacheDir, hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+// lockCachedProject creates dir, dropped first when clean, and returns the
+// lock to hold while it runs.
+func lockCachedProject(dir string, clean bool) (*os.File, error) {
+    if err := os.MkdirAll(execCacheDir, 0700); err != nil {
+        return nil, err
+    }
+    cacheLock, err := lockFile(filepath.Join(execCacheDir, execCacheLockFile), true, true)
+    if err != nil {
+        return nil, err
+    }
+    defer cacheLock.Close()
+
+    if clean {
+        lock, err := lockFile(dir + ".lock", true, false)
+        if err != nil {
+            return nil, errors.New("can not clean " + dir + ", another gaia exec runs it")
+        }
+        err = os.RemoveAll(dir)
+        lock.Close()
+        if err != nil {
+            return nil, err
+        }
+    }
+    if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+        return nil, err
+    }
+    return lockFile(dir + ".lock", false, true)
+}
+
+// touchProjectDir marks a project as used for the LRU.
+func touchProjectDir(dir string) {
+    now := time.Now()
+    os.Chtimes(dir, now, now)
+}
+
+func listCachedProjects() ([]cachedProject, error) {
+    infos, err := ioutil.ReadDir(execCacheDir)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    projects := []cachedProject{}
+    for _, info := range infos {
+        if !info.IsDir() {
+            continue
+        }
+        dir := filepath.Join(execCacheDir, info.Name())
+        projects = append(projects, cachedProject{dir, dirSize(dir), info.ModTime()})
+    }
+    return projects, nil
+}
+
+func dirSize(dir string) int64 {
+    var size int64
+    filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() {
+            size += info.Size()
+        }
+        return nil
+    })
+    return size
+}
+
+// gcExecCache removes least recently used projects until the cache fits
+// in maxSize, keep is never removed. maxSize 0 removes everything but keep.
+func gcExecCache(maxSize int64, keep string) (int, int64, error) {
+    if _, err := os.Stat(execCacheDir); os.IsNotExist(err) {
+        return 0, 0, nil
+    }
+    cacheLock, err := lockFile(filepath.Join(execCacheDir, execCacheLockFile), true, true)
+    if err != nil {
+        return 0, 0, err
+    }
+    defer cacheLock.Close()
+
+    projects, err := listCachedProjects()
+    if err != nil {
+        return 0, 0, err
+    }
+    sort.Slice(projects, func(i, j int) bool {
+        return projects[i].LastUsed.After(projects[j].LastUsed)
+    })
+
+    var total, freed int64
+    removed := 0
+    for _, p := range projects {
+        if p.Dir == keep || total + p.Size <= maxSize {
+            total += p.Size
+            continue
+        }
+        lock, err := lockFile(p.Dir + ".lock", true, false)
+        if err != nil {
+            // running, it counts as used.
+            total += p.Size
+            continue
+        }
+        err = os.RemoveAll(p.Dir)
+        os.Remove(p.Dir + ".lock")
+        lock.Close()
+        if err != nil {
+            return removed, freed, err
+        }
+        removed++
+        freed += p.Size
+    }
+    return removed, freed, nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
-    "bufio"
+    "context"
+    "errors"
     "os"
     "os/exec"
+    "os/signal"
     "fmt"
     "strings"
     "strconv"
+    "syscall"
     "path/filepath"
+    "io"
     "io/ioutil"
+    "time"
 )
 
 var MetaFile string = "meta"
@@ -25,27 +30,65 @@ const (
     SHELL
 )
 
+type ExecOptions struct {
+    Limits ExecLimits
+    FlagLimits ExecLimits
+    Sandbox bool
+    SandboxPaths []string // extra read-only paths
+    Clean bool // drop the cached project before running
+    Private bool // run in a fresh temp dir removed afterwards, for secret nodes
+    CacheSize int64
+}
+
 type Executor struct {
     File string
+    Content string // read instead of File when set, File still names it
     Type ProjectType
     TmpDir  string
     MainFile string
     Command string
+    ExecOptions
+    projectLock *os.File // held while the cached project runs
+    Log io.Writer // progress of the run
+    Out io.Writer // output of the commands
 }
 
 func newExecutor(file string) *Executor {
-    return &Executor{File: file}
+    return &Executor{File: file, Log: os.Stdout, Out: os.Stdout}
 }
 
-func (executor *Executor) Execute() {
-    executor.setType()
-    filesMap := executor.parseFile()
-    executor.generateTmpProject(filesMap)
-    executor.generateBuildScript()
-    executor.buildAndRun()
+func (executor *Executor) Execute() error {
+    if err := executor.setType(); err != nil {
+        return err
+    }
+    filesMap, err := executor.parseFile()
+    if err != nil {
+        return err
+    }
+    if err := executor.setMetaLimits(filesMap); err != nil {
+        return err
+    }
+    defer executor.releaseProject()
+    if err := executor.generateTmpProject(filesMap); err != nil {
+        return err
+    }
+    if executor.Private {
+        // an interrupted private run still removes its files.
+        interrupt := make(chan os.Signal, 1)
+        signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+        go func() {
+            <-interrupt
+            executor.releaseProject()
+            os.Exit(130)
+        }()
+    }
+    if err := executor.generateBuildScript(); err != nil {
+        return err
+    }
+    return executor.buildAndRun()
 }
 
-func (executor *Executor) setType() {
+func (executor *Executor) setType() error {
     ext := filepath.Ext(executor.File)
     switch ext {
     case ".js":
@@ -60,109 +103,122 @@ func (executor *Executor) setType() {
         executor.Type = GO
 
     default:
-        fmt.Println("unsupport script file:" + executor.File)
-        os.Exit(-1)
+        return newCodedError(ERR_INVALID, "unsupport script file:" + executor.File)
     }
+    return nil
 }
 
-/**
-*
-*/
-func (executor *Executor) parseFile() map[string]string {
-    f, err := os.Open(executor.File)
-    if err != nil {
-        panic(err)
+// parseFile unpacks the executed file, see project-format.go.
+func (executor *Executor) parseFile() (map[string]string, error) {
+    var r io.Reader = strings.NewReader(executor.Content)
+    if executor.Content == "" {
+        f, err := os.Open(executor.File)
+        if err != nil {
+            return nil, err
+        }
+        defer f.Close()
+        r = f
     }
-    defer f.Close()
 
-    scanner := bufio.NewScanner(f)
     _, mainFile := filepath.Split(executor.File)
-    currentFileName := mainFile
+    filesMap, mainFile := unpackProject(mainFile, r)
     executor.MainFile = mainFile
-    filesMap := make(map[string]string)
-    fileContent := ""
-    for scanner.Scan() {
-        line := scanner.Text()
-        lineTrimed := strings.TrimSpace(line)
-
-        if strings.HasPrefix(lineTrimed, "#!") {
-            continue
-        }
+    return filesMap, nil
+}
 
-        if lineTrimed == "/***" {
-            currentFileName = MetaFile
-            // fmt.Println("parse meta info")
-            fileContent = ""
-        } else if lineTrimed == "*/" && currentFileName == MetaFile {
-            filesMap[currentFileName] = fileContent
-            currentFileName = mainFile
-            fileContent = ""
-        } else if strings.HasPrefix(lineTrimed, "/**#") && strings.HasSuffix(lineTrimed, "#*/") {
-            filesMap[currentFileName] = fileContent
-            len := len([]rune(lineTrimed))
-            currentFileName = strings.TrimSpace(lineTrimed[4 : len-3])
-            fileContent = ""
-
-            // setup MainFile
-            mainFileContent := filesMap[mainFile]
-            if strings.TrimSpace(mainFileContent) == "" {
-                executor.MainFile = currentFileName
-            }
-        } else{
-            fileContent += line + "\n"
-        }
+// setMetaLimits applies limit.* meta lines, flags given on the command
+// line still win over the meta block.
+func (executor *Executor) setMetaLimits(filesMap map[string]string) error {
+    metaLimits, meta, err := parseMetaLimits(filesMap[MetaFile])
+    if err != nil {
+        return err
     }
-
-    filesMap[currentFileName] = fileContent
-    return filesMap
+    if _, exist := filesMap[MetaFile]; exist {
+        filesMap[MetaFile] = meta
+    }
+    executor.Limits = executor.Limits.merge(metaLimits).merge(executor.FlagLimits)
+    return nil
 }
 
-func (executor *Executor) generateTmpProject(fileMap map[string]string) {
-    projectDir := getTmpProjectDir()
-    if projectDir == "" {
-        fmt.Println("/tmp dir is full, please do some cleanup.")
-        os.Exit(-1)
+func (executor *Executor) generateTmpProject(fileMap map[string]string) error {
+    projectDir, err := executor.projectDir(fileMap)
+    if err != nil {
+        return err
     }
+    executor.TmpDir = projectDir
 
-    os.Mkdir(projectDir, os.ModePerm)
-    fmt.Println("generate temp project in: " + projectDir)
-    sourceFiles := []string{}
     for k, v := range fileMap {
         v = strings.TrimSpace(v)
         if v == "" {
             continue
         }
+        if err := checkProjectFileName(k); err != nil {
+            return err
+        }
         file := projectDir + "/" + k
-        sourceFiles = append(sourceFiles, k)
-        f, err := os.Create(file)
-        if err != nil {
-            panic(err)
+        os.MkdirAll(filepath.Dir(file), os.ModePerm)
+        if err := ioutil.WriteFile(file, []byte(v), 0644); err != nil {
+            return err
         }
-        defer f.Close()
+    }
+    return nil
+}
 
-        f.WriteString(v)
+// projectDir is the cached project of the files, locked while it runs. A
+// private run gets a fresh 0700 dir instead, so decrypted secrets never
+// land in the cache.
+func (executor *Executor) projectDir(fileMap map[string]string) (string, error) {
+    if executor.Private {
+        dir, err := ioutil.TempDir("", "gaia-exec-")
+        if err == nil {
+            fmt.Fprintln(executor.Log, "generate private project in: " + dir)
+        }
+        return dir, err
     }
 
-    executor.TmpDir = projectDir
+    projectDir := execProjectDir(fileMap)
+    if _, err := os.Stat(projectDir); err == nil && !executor.Clean {
+        fmt.Fprintln(executor.Log, "reuse project in: " + projectDir)
+    } else {
+        fmt.Fprintln(executor.Log, "generate project in: " + projectDir)
+    }
+    lock, err := lockCachedProject(projectDir, executor.Clean)
+    if err != nil {
+        return "", err
+    }
+    executor.projectLock = lock
+    touchProjectDir(projectDir)
+    return projectDir, nil
 }
 
-func getTmpProjectDir() string {
-    dir := "/tmp/gaia-tmp-"
-    projectDir := ""
-    for i := 0; i < 10000; i++ {
-        projectDir = dir + strconv.Itoa(i)
-        if _, err := os.Stat(projectDir); os.IsNotExist(err) {
-            break
+// releaseProject removes a private project, or unlocks the cached one and
+// then trims the cache, the project just run is kept.
+func (executor *Executor) releaseProject() {
+    if executor.Private {
+        if executor.TmpDir != "" {
+            os.RemoveAll(executor.TmpDir)
         }
+        return
+    }
+    if executor.projectLock == nil {
+        return
+    }
+    executor.projectLock.Close()
+    executor.projectLock = nil
+
+    removed, freed, err := gcExecCache(executor.CacheSize, executor.TmpDir)
+    if err != nil {
+        fmt.Fprintln(executor.Log, "exec cache gc:", err)
+    } else if removed > 0 {
+        fmt.Fprintf(executor.Log, "exec cache gc: removed %d projects, %d bytes\n", removed, freed)
     }
-    return projectDir
 }
 
-func (executor *Executor) generateBuildScript() {
+func (executor *Executor) generateBuildScript() error {
     metaFile := executor.TmpDir + "/" + MetaFile
     fileContent, err := ioutil.ReadFile(metaFile)
     if err != nil {
-        return
+        return nil
     }
 
     fileLines := strings.Split(string(fileContent), "\n")
@@ -171,14 +227,18 @@ func (executor *Executor) generateBuildScript() {
         generateNodeFile(fileLines, executor.TmpDir)
         executor.Command = "npm install; node " + executor.MainFile
     case SCALA:
+        fmt.Fprintln(executor.Log, "props", fileLines)
         generateSbtFile(fileLines, executor.TmpDir)
-        appFile := refactorScalaMainFile(executor.TmpDir, executor.MainFile)
+        appFile, err := refactorScalaMainFile(executor.TmpDir, executor.MainFile)
+        if err != nil {
+            return err
+        }
         executor.MainFile = appFile
         executor.Command = "sbt run"
     default:
-        fmt.Println("executor type not implemented yet:" + string(executor.Type))
-        os.Exit(-1)
+        return newCodedError(ERR_NOT_IMPLEMENTED, "executor type not implemented yet:" + strconv.Itoa(int(executor.Type)))
     }
+    return nil
 }
 
 // TODO: add default props:
@@ -260,7 +320,6 @@ func generateNodeFile(fileLines []string, projectDir string) {
 
 // TODO: setup default fields.
 func generateSbtFile(props []string, projectDir string) {
-    fmt.Println("props", props)
 
     replaceDepsName := func(line string) string {
         res := line
@@ -284,7 +343,7 @@ func generateSbtFile(props []string, projectDir string) {
     sbtFile.WriteString(lines)
 }
 
-func refactorScalaMainFile(tmpDir, mainFile string) string {
+func refactorScalaMainFile(tmpDir, mainFile string) (string, error) {
     parseMainClassName := func() string {
         className := strings.TrimRight(mainFile, ".scala")
         if strings.Index(className, "-") > 0 {
@@ -302,8 +361,7 @@ func refactorScalaMainFile(tmpDir, mainFile string) string {
     f := tmpDir + "/" + mainFile
     fileContent, err := ioutil.ReadFile(f)
     if err != nil {
-        fmt.Println(err)
-        os.Exit(-1)
+        return "", err
     }
 
     fileLines := strings.Split(string(fileContent), "\n")
@@ -331,27 +389,89 @@ func refactorScalaMainFile(tmpDir, mainFile string) string {
     appFile, _ := os.Create(tmpDir + "/" + appFileName + ".scala")
     defer appFile.Close()
     appFile.WriteString(newFileLines)
-    return appFileName + ".scala"
+    return appFileName + ".scala", nil
 }
 
-func (executor *Executor) buildAndRun() {
-    fmt.Printf("executor: %+v\n", executor)
+// buildAndRun runs the commands, the first failing one ends the run.
+func (executor *Executor) buildAndRun() error {
+    // never Content, it holds the decrypted text of secret nodes.
+    fmt.Fprintf(executor.Log, "executor: file %s, main %s, dir %s, command %s\n", executor.File, executor.MainFile, executor.TmpDir, executor.Command)
+    if executor.Limits != (ExecLimits{}) {
+        fmt.Fprintln(executor.Log, "limits:", executor.Limits)
+    }
+
+    var sb *Sandbox
+    if executor.Sandbox {
+        var err error
+        sb, err = newSandbox(executor.TmpDir, executor.SandboxPaths, executor.Limits.NoNetwork)
+        if err != nil {
+            return err
+        }
+    }
+
+    // the timeout covers the whole run, build steps included.
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    if executor.Limits.Timeout > 0 {
+        ctx, cancel = context.WithTimeout(ctx, executor.Limits.Timeout)
+        defer cancel()
+    }
+
     commands := strings.Split(executor.Command, ";")
     for _, cmdStr := range commands {
         cmdStr = strings.TrimSpace(cmdStr)
         if cmdStr == "" {
             continue
         }
-        fmt.Println("run command:", cmdStr)
-        parts := strings.Split(cmdStr, " ")
-        length := len(parts)
-        cmd := exec.Command(parts[0], parts[1:length]...)
-        cmd.Dir = executor.TmpDir
-        out, err := cmd.CombinedOutput()
+        fmt.Fprintln(executor.Log, "run command:", cmdStr)
+        err := executor.runCommand(ctx, cancel, strings.Split(cmdStr, " "), sb)
         if err != nil {
-            fmt.Printf("error: %s\n", err)
-            os.Exit(-1)
+            return err
+        }
+    }
+    return nil
+}
+
+func (executor *Executor) runCommand(ctx context.Context, cancel context.CancelFunc, parts []string, sb *Sandbox) error {
+    limiter := newProcLimiter(executor.Limits)
+    defer limiter.close()
+
+    command := parts[0]
+    if executor.Limits.Memory > 0 && !limiter.memoryHandled() && reservesAddressSpace(executor.Type) {
+        fmt.Fprintln(executor.Log, "warning: no cgroup for the memory limit, ulimit -v caps the address space instead, " + command + " may fail far below the limit")
+    }
+    parts = rlimitWrap(parts, executor.Limits, limiter.memoryHandled())
+    if sb != nil {
+        sb.addToolchain(command)
+        parts = sb.wrap(parts)
+    }
+    cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+    cmd.Dir = executor.TmpDir
+    if sb != nil {
+        cmd.Env = sb.env()
+    }
+    cmd.WaitDelay = 2 * time.Second
+    out := newLimitedWriter(executor.Out, executor.Limits.MaxOutput, cancel)
+    cmd.Stdout = out
+    cmd.Stderr = out
+    if err := limiter.apply(cmd, sb); err != nil {
+        return err
+    }
+
+    err := cmd.Run()
+    if out.exceeded {
+        return fmt.Errorf("output limit of %d bytes exceeded", executor.Limits.MaxOutput)
+    }
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return errors.New("timed out after " + executor.Limits.Timeout.String())
+    }
+    if err != nil && cmd.Process == nil {
+        if sb != nil && sb.Mode == NAMESPACE_SANDBOX {
+            return errors.New("can not start the sandbox namespaces: " + err.Error())
+        }
+        if executor.Limits.NoNetwork {
+            return errors.New("can not start in a network namespace: " + err.Error())
         }
-        fmt.Print(string(out))
     }
+    return err
 }
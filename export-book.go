@@ -0,0 +1,307 @@
+package main
+
+import (
+    "fmt"
+    "html"
+    "io/ioutil"
+    "strings"
+)
+
+// ExportOptions narrows an export down to some nodes, empty fields match all.
+type ExportOptions struct {
+    Category string
+    Tag string
+    Unlock bool // include secret nodes, decrypted
+}
+
+func filterExportNodes(nodes []Node, options ExportOptions) []Node {
+    res := []Node{}
+    for _, node := range nodes {
+        if options.Category != "" && node.Category != options.Category {
+            continue
+        }
+        if options.Tag != "" && !hasTag(node, options.Tag) {
+            continue
+        }
+        if node.Secret && !options.Unlock {
+            continue
+        }
+        res = append(res, node)
+    }
+    return res
+}
+
+func hasTag(node Node, tag string) bool {
+    for _, t := range splitList(node.Tags) {
+        if strings.EqualFold(t, tag) {
+            return true
+        }
+    }
+    return false
+}
+
+// bookEntry is a line of the table of contents: a category, a branch or a
+// node, nodes point to their section.
+type bookEntry struct {
+    Level int
+    Title string
+    Node *Node
+}
+
+// bookContents orders nodes by name and puts category and branch headings
+// in front of them, the same grouping as `gaia list`.
+func bookContents(nodes []Node) []bookEntry {
+    sortNodesByName(nodes)
+    entries := []bookEntry{}
+    lastCategory, lastBranch := "", ""
+    for i := range nodes {
+        node := &nodes[i]
+        category := strings.Split(node.Name, "-")[0]
+        if category != lastCategory {
+            entries = append(entries, bookEntry{0, category, nil})
+            lastCategory, lastBranch = category, ""
+        }
+        level := 1
+        if branch := node.GetBranch(); branch != node.Name {
+            if branch != lastBranch {
+                entries = append(entries, bookEntry{1, branch, nil})
+                lastBranch = branch
+            }
+            level = 2
+        }
+        entries = append(entries, bookEntry{level, node.Name, node})
+    }
+    return entries
+}
+
+var tokenPdfColors = map[string]pdfColor{
+    TOKEN_KEYWORD: {0.0, 0.2, 0.6},
+    TOKEN_STRING: {0.1, 0.45, 0.1},
+    TOKEN_COMMENT: {0.5, 0.5, 0.5},
+    TOKEN_NUMBER: {0.6, 0.3, 0.0},
+}
+
+var pdfGray = pdfColor{0.4, 0.4, 0.4}
+
+const (
+    bookMargin = 50.0
+    bookTocLineHeight = 15.0
+    bookCodeSize = 9.0
+    bookCodeLineHeight = 11.0
+)
+
+// bookWriter lays out text top down and starts new pages as needed.
+type bookWriter struct {
+    doc *pdfDoc
+    page int
+    y float64
+}
+
+func (bw *bookWriter) newPage() {
+    bw.page = bw.doc.addPage()
+    bw.y = pdfPageHeight - bookMargin
+}
+
+// ensure starts a new page when less than height is left on this one.
+func (bw *bookWriter) ensure(height float64) {
+    if bw.y - height < bookMargin {
+        bw.newPage()
+    }
+}
+
+func (bw *bookWriter) textLine(font string, size float64, color pdfColor, s string) {
+    bw.ensure(size * 1.4)
+    bw.y -= size * 1.4
+    bw.doc.text(bw.page, bookMargin, bw.y, font, size, color, s)
+}
+
+// paragraph wraps s at word boundaries to the page width.
+func (bw *bookWriter) paragraph(font string, size float64, color pdfColor, s string) {
+    maxWidth := pdfPageWidth - 2 * bookMargin
+    for _, para := range strings.Split(s, "\n") {
+        line := ""
+        for _, word := range strings.Fields(para) {
+            next := strings.TrimSpace(line + " " + word)
+            if line != "" && pdfTextWidth(font, size, next) > maxWidth {
+                bw.textLine(font, size, color, line)
+                next = word
+            }
+            line = next
+        }
+        bw.textLine(font, size, color, line)
+    }
+}
+
+// code prints highlighted lines, long lines are wrapped and continued
+// with a small indent.
+func (bw *bookWriter) code(lines [][]codeToken) {
+    width := pdfPageWidth - 2 * bookMargin
+    maxChars := int(width / (0.6 * bookCodeSize))
+    for _, tokens := range lines {
+        bw.ensure(bookCodeLineHeight)
+        bw.y -= bookCodeLineHeight
+        col := 0
+        for _, token := range tokens {
+            rs := []rune(token.Text)
+            for len(rs) > 0 {
+                if col >= maxChars {
+                    bw.ensure(bookCodeLineHeight)
+                    bw.y -= bookCodeLineHeight
+                    col = 2
+                }
+                n := maxChars - col
+                if n > len(rs) {
+                    n = len(rs)
+                }
+                color, ok := tokenPdfColors[token.Kind]
+                if !ok {
+                    color = pdfBlack
+                }
+                x := bookMargin + float64(col) * 0.6 * bookCodeSize
+                bw.doc.text(bw.page, x, bw.y, PDF_MONO, bookCodeSize, color, string(rs[:n]))
+                col += n
+                rs = rs[n:]
+            }
+        }
+    }
+}
+
+// exportPdf writes nodes as a printable book: the table of contents, then
+// a section per node with its content highlighted.
+func exportPdf(nodes []Node, file string, title string) (int, error) {
+    entries := bookContents(nodes)
+    doc := newPdfDoc(title)
+    bw := &bookWriter{doc: doc}
+
+    // the contents come first but need the page numbers of the sections,
+    // so reserve their pages and fill them in last.
+    height := pdfPageHeight - 2 * bookMargin
+    perPage := int(height / bookTocLineHeight)
+    tocLines := len(entries) + 3
+    tocPages := (tocLines + perPage - 1) / perPage
+    for i := 0; i < tocPages; i++ {
+        doc.addPage()
+    }
+
+    startPages := make(map[string]int)
+    bw.newPage()
+    for i, node := range nodes {
+        if i > 0 {
+            bw.ensure(80)
+            if bw.y < pdfPageHeight - bookMargin {
+                bw.y -= 12
+                doc.line(bw.page, bookMargin, bw.y, pdfPageWidth - bookMargin, bw.y, 0.5)
+                bw.y -= 4
+            }
+        }
+        bw.ensure(80)
+        startPages[node.Id] = bw.page
+        bw.textLine(PDF_SANS_BOLD, 14, pdfBlack, node.Name)
+        meta := "ID: " + node.Id
+        if node.Tags != "" {
+            meta += "    TAGS: " + node.Tags
+        }
+        if node.Executable {
+            meta += "    FILE: " + node.ExecFile
+        }
+        bw.textLine(PDF_SANS, 9, pdfGray, meta)
+        if node.Desc != "" {
+            bw.paragraph(PDF_SANS, 10, pdfBlack, node.Desc)
+        }
+        bw.y -= 6
+        bw.code(highlightCode(node.Content, nodeLanguage(node)))
+    }
+
+    bw.page, bw.y = 0, pdfPageHeight - bookMargin
+    bw.textLine(PDF_SANS_BOLD, 20, pdfBlack, title)
+    bw.y -= bookTocLineHeight
+    for _, entry := range entries {
+        if bw.y - bookTocLineHeight < bookMargin {
+            bw.page, bw.y = bw.page + 1, pdfPageHeight - bookMargin
+        }
+        bw.y -= bookTocLineHeight
+        x := bookMargin + float64(entry.Level) * 14
+        if entry.Node == nil {
+            doc.text(bw.page, x, bw.y, PDF_SANS_BOLD, 10, pdfBlack, entry.Title)
+            continue
+        }
+        pageNum := fmt.Sprintf("%d", startPages[entry.Node.Id] + 1)
+        doc.text(bw.page, x, bw.y, PDF_SANS, 10, pdfBlack, entry.Title)
+        doc.text(bw.page, pdfPageWidth - bookMargin - 110, bw.y, PDF_MONO, 8, pdfGray, entry.Node.Id)
+        doc.text(bw.page, pdfPageWidth - bookMargin - pdfTextWidth(PDF_SANS, 10, pageNum), bw.y, PDF_SANS, 10, pdfBlack, pageNum)
+    }
+
+    for i := range doc.pages {
+        pageNum := fmt.Sprintf("%d", i + 1)
+        doc.text(i, (pdfPageWidth - pdfTextWidth(PDF_SANS, 9, pageNum)) / 2, bookMargin / 2, PDF_SANS, 9, pdfGray, pageNum)
+    }
+    return len(nodes), ioutil.WriteFile(file, doc.bytes(), 0644)
+}
+
+const bookHtmlStyle = `body { font-family: sans-serif; max-width: 50em; margin: 2em auto; color: #222; }
+nav ul { list-style: none; padding: 0; }
+nav .level1 { padding-left: 1.2em; } nav .level2 { padding-left: 2.4em; }
+nav .id, .meta { color: #666; font-size: 0.85em; }
+section { border-top: 1px solid #ccc; margin-top: 2em; }
+pre { background: #f6f6f6; padding: 0.8em; overflow-x: auto; font-size: 0.85em; }
+.kw { color: #039; font-weight: bold; } .str { color: #172; } .com { color: #888; } .num { color: #a50; }
+@media print { section { page-break-before: always; border: none; } pre { white-space: pre-wrap; } }
+`
+
+// highlightHtml renders tokens as spans classed by their kind.
+func highlightHtml(lines [][]codeToken) string {
+    res := []string{}
+    for _, tokens := range lines {
+        line := ""
+        for _, token := range tokens {
+            text := html.EscapeString(token.Text)
+            if token.Kind == "" {
+                line += text
+            } else {
+                line += `<span class="` + token.Kind + `">` + text + `</span>`
+            }
+        }
+        res = append(res, line)
+    }
+    return strings.Join(res, "\n")
+}
+
+// exportHtml writes the same book as exportPdf into one html page.
+func exportHtml(nodes []Node, file string, title string) (int, error) {
+    entries := bookContents(nodes)
+    var sb strings.Builder
+    esc := html.EscapeString
+    sb.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+    sb.WriteString("<title>" + esc(title) + "</title>\n<style>\n" + bookHtmlStyle + "</style>\n</head>\n<body>\n")
+    sb.WriteString("<h1>" + esc(title) + "</h1>\n<nav>\n")
+
+    sb.WriteString("<ul>\n")
+    for _, entry := range entries {
+        class := fmt.Sprintf(`class="level%d"`, entry.Level)
+        if entry.Node == nil {
+            sb.WriteString("<li " + class + "><b>" + esc(entry.Title) + "</b></li>\n")
+        } else {
+            sb.WriteString("<li " + class + `><a href="#` + esc(entry.Node.Id) + `">` + esc(entry.Title) + `</a> <span class="id">` + esc(entry.Node.Id) + "</span></li>\n")
+        }
+    }
+    sb.WriteString("</ul>\n")
+    sb.WriteString("</nav>\n")
+
+    for _, node := range nodes {
+        sb.WriteString(`<section id="` + esc(node.Id) + `">` + "\n<h2>" + esc(node.Name) + "</h2>\n")
+        meta := "ID: " + node.Id
+        if node.Tags != "" {
+            meta += " &middot; TAGS: " + esc(node.Tags)
+        }
+        if node.Executable {
+            meta += " &middot; FILE: " + esc(node.ExecFile)
+        }
+        sb.WriteString(`<p class="meta">` + meta + "</p>\n")
+        if node.Desc != "" {
+            sb.WriteString("<p>" + esc(node.Desc) + "</p>\n")
+        }
+        sb.WriteString("<pre><code>" + highlightHtml(highlightCode(node.Content, nodeLanguage(node))) + "</code></pre>\n</section>\n")
+    }
+    sb.WriteString("</body>\n</html>\n")
+    return len(nodes), ioutil.WriteFile(file, []byte(sb.String()), 0644)
+}
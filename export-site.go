@@ -0,0 +1,295 @@
+package main
+
+import (
+    "encoding/json"
+    "html"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// a site export is laid out as
+//   index.html           overview and search
+//   nodes/<id>.html      a page per node
+//   tags/index.html      all tags
+//   tags/<tag>.html      the nodes of a tag
+//   search.json          the index search.js loads
+//   style.css, search.js
+const siteSearchIndexFile = "search.json"
+
+var siteGeneratedDirs = []string{"nodes", "tags"}
+
+const siteStyle = `body { margin: 0; font-family: sans-serif; color: #222; display: flex; }
+aside { width: 16em; min-height: 100vh; padding: 1em; background: #f4f4f4; box-sizing: border-box; font-size: 0.9em; }
+aside ul { list-style: none; padding-left: 1em; margin: 0.2em 0; }
+aside > ul { padding-left: 0; }
+main { flex: 1; max-width: 50em; padding: 1em 2em; }
+a { color: #036; text-decoration: none; } a:hover { text-decoration: underline; }
+.meta { color: #666; font-size: 0.85em; }
+.tag { background: #e8eef6; border-radius: 3px; padding: 0 0.4em; margin-right: 0.3em; }
+pre { background: #f6f6f6; padding: 0.8em; overflow-x: auto; font-size: 0.85em; }
+.kw { color: #039; font-weight: bold; } .str { color: #172; } .com { color: #888; } .num { color: #a50; }
+#search { width: 100%; padding: 0.4em; font-size: 1em; box-sizing: border-box; }
+`
+
+const siteSearchScript = `(function() {
+    var input = document.getElementById("search");
+    var results = document.getElementById("results");
+    if (!input) {
+        return;
+    }
+    var root = input.getAttribute("data-root");
+    var index = [];
+    fetch(root + "search.json").then(function(res) { return res.json(); }).then(function(data) {
+        index = data;
+    });
+
+    input.addEventListener("input", function() {
+        var words = input.value.toLowerCase().split(/\s+/).filter(function(w) { return w; });
+        results.innerHTML = "";
+        if (words.length === 0) {
+            return;
+        }
+        index.filter(function(item) {
+            var text = (item.Name + " " + item.Tags + " " + item.Desc + " " + item.Text).toLowerCase();
+            return words.every(function(w) { return text.indexOf(w) >= 0; });
+        }).slice(0, 50).forEach(function(item) {
+            var li = document.createElement("li");
+            var a = document.createElement("a");
+            a.href = root + item.Url;
+            a.textContent = item.Name;
+            li.appendChild(a);
+            if (item.Desc) {
+                li.appendChild(document.createTextNode(" - " + item.Desc));
+            }
+            results.appendChild(li);
+        });
+    });
+})();
+`
+
+// siteSearchItem is an entry of search.json.
+type siteSearchItem struct {
+    Id string
+    Name string
+    Tags string
+    Desc string
+    Url string
+    Text string
+}
+
+func siteNodeUrl(id string) string {
+    return "nodes/" + id + ".html"
+}
+
+// siteTagUrl keeps tag file names safe, tags are free text. A tag the slug
+// changed, like c++ or Go, gets a hash of it so it can not take the page
+// of c__ or go, and index is left to the tag list.
+func siteTagUrl(tag string) string {
+    slug := strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+            return r
+        }
+        return '_'
+    }, strings.ToLower(tag))
+    if slug != tag || slug == "index" {
+        slug += "-" + sha256Hex([]byte(tag))[:8]
+    }
+    return "tags/" + slug + ".html"
+}
+
+// siteSidebar renders the tree of `gaia list -c`: categories, their second
+// name parts, then the nodes below those.
+func siteSidebar(nodes []Node, categories map[string][]string, root string) string {
+    esc := html.EscapeString
+    nodeLink := func(node Node) string {
+        return `<li><a href="` + root + siteNodeUrl(node.Id) + `">` + esc(node.Name) + "</a></li>\n"
+    }
+
+    // categories with only single part names are not in the map.
+    cates := []string{}
+    for cate := range categories {
+        cates = append(cates, cate)
+    }
+    for _, node := range nodes {
+        if !ArrContains(cates, node.Category) {
+            cates = append(cates, node.Category)
+        }
+    }
+    sort.Strings(cates)
+
+    var sb strings.Builder
+    sb.WriteString(`<aside><a href="` + root + `index.html"><b>Gaia</b></a> &middot; <a href="` + root + `tags/index.html">tags</a>` + "\n<ul>\n")
+    for _, cate := range cates {
+        cateNodes := ""
+        for _, node := range nodes {
+            if node.Category == cate && !strings.Contains(node.Name, "-") {
+                cateNodes += nodeLink(node)
+            }
+        }
+        middles := append([]string{}, categories[cate]...)
+        sort.Strings(middles)
+        for _, middle := range middles {
+            prefix := cate + "-" + middle
+            branchNodes := ""
+            for _, node := range nodes {
+                if node.Name == prefix || strings.HasPrefix(node.Name, prefix + "-") {
+                    branchNodes += nodeLink(node)
+                }
+            }
+            if branchNodes != "" {
+                cateNodes += "<li>" + esc(middle) + "\n<ul>\n" + branchNodes + "</ul></li>\n"
+            }
+        }
+        if cateNodes != "" {
+            sb.WriteString("<li><b>" + esc(cate) + "</b>\n<ul>\n" + cateNodes + "</ul></li>\n")
+        }
+    }
+    sb.WriteString("</ul>\n</aside>\n")
+    return sb.String()
+}
+
+func sitePage(title string, root string, sidebar string, body string) string {
+    esc := html.EscapeString
+    return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n" +
+        "<title>" + esc(title) + "</title>\n" +
+        `<link rel="stylesheet" href="` + root + `style.css">` + "\n</head>\n<body>\n" +
+        sidebar + "<main>\n" + body + "</main>\n" +
+        `<script src="` + root + `search.js"></script>` + "\n</body>\n</html>\n"
+}
+
+func siteNodeList(nodes []Node, root string) string {
+    esc := html.EscapeString
+    res := "<ul>\n"
+    for _, node := range nodes {
+        res += `<li><a href="` + root + siteNodeUrl(node.Id) + `">` + esc(node.Name) + "</a>"
+        if node.Desc != "" {
+            res += " - " + esc(node.Desc)
+        }
+        res += "</li>\n"
+    }
+    return res + "</ul>\n"
+}
+
+// exportSite writes a static site browsable without gaia, see the layout
+// above. Pages left from an earlier export into dir are removed.
+func exportSite(nodes []Node, categories map[string][]string, dir string) (int, error) {
+    sortNodesByName(nodes)
+    esc := html.EscapeString
+
+    if _, err := os.Stat(filepath.Join(dir, siteSearchIndexFile)); err == nil {
+        for _, sub := range siteGeneratedDirs {
+            if err := os.RemoveAll(filepath.Join(dir, sub)); err != nil {
+                return 0, err
+            }
+        }
+    }
+    write := func(rel string, text string) error {
+        file := filepath.Join(dir, filepath.FromSlash(rel))
+        if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+            return err
+        }
+        return ioutil.WriteFile(file, []byte(text), 0644)
+    }
+
+    nodeById := make(map[string]Node)
+    tagNodes := make(map[string][]Node)
+    backlinks := make(map[string][]Node)
+    for _, node := range nodes {
+        nodeById[node.Id] = node
+        for _, tag := range splitList(node.Tags) {
+            tagNodes[tag] = append(tagNodes[tag], node)
+        }
+    }
+    for _, node := range nodes {
+        for _, linkId := range splitList(node.Links) {
+            backlinks[linkId] = append(backlinks[linkId], node)
+        }
+    }
+
+    rootSidebar := siteSidebar(nodes, categories, "")
+    subSidebar := siteSidebar(nodes, categories, "../")
+
+    searchItems := []siteSearchItem{}
+    for _, node := range nodes {
+        body := "<h1>" + esc(node.Name) + "</h1>\n"
+        meta := "ID: " + esc(node.Id)
+        if node.Executable {
+            meta += " &middot; FILE: " + esc(node.ExecFile)
+        }
+        body += `<p class="meta">` + meta + "</p>\n"
+        if tags := splitList(node.Tags); len(tags) > 0 {
+            body += "<p>"
+            for _, tag := range tags {
+                body += `<a class="tag" href="../` + siteTagUrl(tag) + `">` + esc(tag) + "</a>"
+            }
+            body += "</p>\n"
+        }
+        if node.Desc != "" {
+            body += "<p>" + esc(node.Desc) + "</p>\n"
+        }
+        body += "<pre><code>" + highlightHtml(highlightCode(node.Content, nodeLanguage(node))) + "</code></pre>\n"
+
+        links := []Node{}
+        for _, linkId := range splitList(node.Links) {
+            if linked, ok := nodeById[linkId]; ok {
+                links = append(links, linked)
+            }
+        }
+        if len(links) > 0 {
+            body += "<h3>Links</h3>\n" + siteNodeList(links, "../")
+        }
+        if len(backlinks[node.Id]) > 0 {
+            body += "<h3>Backlinks</h3>\n" + siteNodeList(backlinks[node.Id], "../")
+        }
+
+        if err := write(siteNodeUrl(node.Id), sitePage(node.Name, "../", subSidebar, body)); err != nil {
+            return 0, err
+        }
+        searchItems = append(searchItems, siteSearchItem{node.Id, node.Name, node.Tags, node.Desc, siteNodeUrl(node.Id), node.Content})
+    }
+
+    tags := []string{}
+    for tag := range tagNodes {
+        tags = append(tags, tag)
+    }
+    sort.Strings(tags)
+    tagIndex := "<h1>Tags</h1>\n<ul>\n"
+    for _, tag := range tags {
+        tagIndex += `<li><a href="../` + siteTagUrl(tag) + `">` + esc(tag) + "</a> (" + strconv.Itoa(len(tagNodes[tag])) + ")</li>\n"
+        body := "<h1>#" + esc(tag) + "</h1>\n" + siteNodeList(tagNodes[tag], "../")
+        if err := write(siteTagUrl(tag), sitePage("#" + tag, "../", subSidebar, body)); err != nil {
+            return 0, err
+        }
+    }
+    tagIndex += "</ul>\n"
+    if err := write("tags/index.html", sitePage("Tags", "../", subSidebar, tagIndex)); err != nil {
+        return 0, err
+    }
+
+    index := "<h1>Gaia</h1>\n" +
+        `<p><input id="search" data-root="" placeholder="search ` + strconv.Itoa(len(nodes)) + ` nodes" autofocus></p>` + "\n" +
+        `<ul id="results"></ul>` + "\n"
+    if err := write("index.html", sitePage("Gaia", "", rootSidebar, index)); err != nil {
+        return 0, err
+    }
+
+    searchJson, err := json.Marshal(searchItems)
+    if err != nil {
+        return 0, err
+    }
+    files := map[string]string{
+        siteSearchIndexFile: string(searchJson),
+        "style.css": siteStyle,
+        "search.js": siteSearchScript,
+    }
+    for rel, text := range files {
+        if err := write(rel, text); err != nil {
+            return 0, err
+        }
+    }
+    return len(nodes), nil
+}
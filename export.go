@@ -0,0 +1,224 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// every exported directory gets an index, import skips these files.
+const exportIndexFile = "_index.md"
+const exportManifestFile = ".gaia-export.json"
+
+var extLanguageMap = map[string]string{
+    ".js": "javascript",
+    ".ts": "typescript",
+    ".py": "python",
+    ".rb": "ruby",
+    ".rs": "rust",
+    ".sh": "sh",
+    ".yml": "yaml",
+}
+
+// fenceLanguage names the code fence language of an executable node, the
+// empty string means the content is markdown and stays unfenced.
+func fenceLanguage(node Node) string {
+    ext := strings.ToLower(filepath.Ext(node.ExecFile))
+    if ext == "" {
+        return ""
+    }
+    if lang, ok := extLanguageMap[ext]; ok {
+        return lang
+    }
+    return ext[1:]
+}
+
+// fenceCode wraps content in a fence longer than any backtick run in it.
+func fenceCode(content string, lang string) string {
+    fence := "```"
+    for strings.Contains(content, fence) {
+        fence += "`"
+    }
+    return fence + lang + "\n" + content + "\n" + fence
+}
+
+// unfenceCode is the inverse of fenceCode, ok is false when body is not a
+// single fenced block.
+func unfenceCode(body string) (string, bool) {
+    body = strings.TrimRight(body, "\n")
+    lines := strings.Split(body, "\n")
+    if len(lines) < 2 || !strings.HasPrefix(lines[0], "```") {
+        return body, false
+    }
+    fence := lines[0][:len(lines[0]) - len(strings.TrimLeft(lines[0], "`"))]
+    if lines[len(lines) - 1] != fence {
+        return body, false
+    }
+    return strings.Join(lines[1:len(lines) - 1], "\n"), true
+}
+
+// nodeMarkdownFile is the path of a node below the export dir: name parts
+// become directories, the last part the file.
+func nodeMarkdownFile(name string) string {
+    return filepath.Join(strings.Split(name, "-")...) + ".md"
+}
+
+func nodeExportText(node Node) string {
+    if lang := fenceLanguage(node); lang != "" {
+        node.Content = fenceCode(node.Content, lang)
+    }
+    return node.Markdown()
+}
+
+func sortNodesByName(nodes []Node) {
+    sort.Slice(nodes, func(i, j int) bool {
+        return nodes[i].Name < nodes[j].Name
+    })
+}
+
+// exportMarkdown writes one file per node plus an index per directory.
+// The files written are listed in the export manifest, a later export into
+// dir removes only listed files of nodes that left the store or moved, so
+// hand written files and nodes a --category or --tag filter left out stay.
+// existing holds the ids in the store.
+func exportMarkdown(nodes []Node, dir string, existing map[string]bool) (int, error) {
+    sortNodesByName(nodes)
+    manifest := make(map[string]string) // file relative to dir -> node id, "" for indexes
+    write := func(rel string, id string, text string) error {
+        if err := checkProjectFileName(rel); err != nil {
+            return err
+        }
+        file := filepath.Join(dir, rel)
+        if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+            return err
+        }
+        manifest[filepath.ToSlash(rel)] = id
+        return ioutil.WriteFile(file, []byte(text), 0644)
+    }
+    old := readExportManifest(dir)
+
+    for _, node := range nodes {
+        if err := write(nodeMarkdownFile(node.Name), node.Id, nodeExportText(node)); err != nil {
+            return 0, err
+        }
+    }
+
+    root := nodesToTree(nodes, "")
+    var writeIndex func(tn *TreeNode, parts []string) error
+    writeIndex = func(tn *TreeNode, parts []string) error {
+        title := "Index"
+        if len(parts) > 0 {
+            title = strings.Join(parts, "-")
+        }
+        text := "# " + title + "\n\n" + markdownTreeList(tn, "", "") + "\n"
+        if err := write(filepath.Join(append(parts, exportIndexFile)...), "", text); err != nil {
+            return err
+        }
+        for _, child := range tn.Children {
+            if len(child.Children) > 0 {
+                if err := writeIndex(child, append(append([]string{}, parts...), child.Name)); err != nil {
+                    return err
+                }
+            }
+        }
+        return nil
+    }
+    if err := writeIndex(root, []string{}); err != nil {
+        return 0, err
+    }
+
+    removeStaleExportFiles(dir, old, manifest, existing)
+    return len(nodes), writeExportManifest(dir, manifest)
+}
+
+func readExportManifest(dir string) map[string]string {
+    manifest := make(map[string]string)
+    if bs, err := ioutil.ReadFile(filepath.Join(dir, exportManifestFile)); err == nil {
+        json.Unmarshal(bs, &manifest)
+    }
+    return manifest
+}
+
+func writeExportManifest(dir string, manifest map[string]string) error {
+    bs, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(filepath.Join(dir, exportManifestFile), bs, 0644)
+}
+
+// markdownTreeList renders the children of tn as a nested list, linking
+// nodes to their file and branches to their index.
+func markdownTreeList(tn *TreeNode, relDir string, indent string) string {
+    res := ""
+    for _, child := range tn.Children {
+        label := child.Name
+        childDir := filepath.ToSlash(filepath.Join(relDir, child.Name))
+        if child.Id != "" {
+            label = "[" + child.Name + "](" + childDir + ".md) `" + child.Id + "`"
+        } else if len(child.Children) > 0 {
+            label = "[" + child.Name + "/](" + childDir + "/" + exportIndexFile + ")"
+        }
+        res += indent + "- " + label + "\n"
+        res += markdownTreeList(child, childDir, indent + "  ")
+    }
+    return res
+}
+
+// removeStaleExportFiles drops the files of old, the previous manifest,
+// that this export did not write: node files whose node is gone or was
+// written elsewhere now, and indexes with no listed file left below them.
+// The files kept are added to manifest.
+func removeStaleExportFiles(dir string, old map[string]string, manifest map[string]string, existing map[string]bool) {
+    writtenIds := make(map[string]bool)
+    for _, id := range manifest {
+        writtenIds[id] = true
+    }
+    stale := []string{}
+    for rel, id := range old {
+        if _, written := manifest[rel]; written || id == "" {
+            continue
+        }
+        if existing[id] && !writtenIds[id] {
+            manifest[rel] = id
+        } else {
+            stale = append(stale, rel)
+        }
+    }
+    for rel, id := range old {
+        if _, written := manifest[rel]; written || id != "" {
+            continue
+        }
+        prefix := path.Dir(rel) + "/"
+        used := false
+        for other, otherId := range manifest {
+            if otherId != "" && (prefix == "./" || strings.HasPrefix(other, prefix)) {
+                used = true
+                break
+            }
+        }
+        if used {
+            manifest[rel] = ""
+        } else {
+            stale = append(stale, rel)
+        }
+    }
+
+    for _, rel := range stale {
+        if checkProjectFileName(rel) != nil {
+            continue
+        }
+        file := filepath.Join(dir, filepath.FromSlash(rel))
+        os.Remove(file)
+        // empty dirs up to dir go too, os.Remove leaves the others alone.
+        for d := filepath.Dir(file); d != filepath.Clean(dir) && isUnderDir(d, dir); d = filepath.Dir(d) {
+            if os.Remove(d) != nil {
+                break
+            }
+        }
+    }
+}
@@ -0,0 +1,29 @@
+// +build linux
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// lockFile opens path and takes a flock on it, shared or exclusive. Without
+// wait a lock held elsewhere fails at once. Closing the file unlocks it.
+func lockFile(path string, exclusive bool, wait bool) (*os.File, error) {
+    f, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0600)
+    if err != nil {
+        return nil, err
+    }
+    how := syscall.LOCK_SH
+    if exclusive {
+        how = syscall.LOCK_EX
+    }
+    if !wait {
+        how |= syscall.LOCK_NB
+    }
+    if err := syscall.Flock(int(f.Fd()), how); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package main
+
+import (
+    "os"
+)
+
+// lockFile only opens path outside linux, runs of gaia are not kept from
+// each other there, see flock_linux.go.
+func lockFile(path string, exclusive bool, wait bool) (*os.File, error) {
+    return os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0600)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+)
+
+// `gaia admin fsck` checks that the maps of GaiaData agree:
+//   - NodeMap keys are the node ids, Category is the first name part
+//   - NameIdMap and NodeMap name the same nodes, names are unique
+//   - ids start with the prefixes of their category and branch, prefixes
+//     belong to one name and branch prefixes to their category's prefix
+//   - no prefixes of categories or branches without nodes
+//   - links point to nodes, attachments exist, card states have nodes
+//   - aliases do not point to themselves or form cycles
+// --repair fixes all but alias cycles, a node whose id does not fit gets
+// a new one and the links to it follow.
+const (
+    FSCK_NODE_ID = "node-id"
+    FSCK_CATEGORY = "category"
+    FSCK_NAME_MAP = "name-map"
+    FSCK_DUPLICATE_NAME = "duplicate-name"
+    FSCK_ID_PREFIX = "id-prefix"
+    FSCK_PREFIX_MAP = "prefix-map"
+    FSCK_DANGLING_LINK = "dangling-link"
+    FSCK_DANGLING_ATTACHMENT = "dangling-attachment"
+    FSCK_ORPHAN_CARD = "orphan-card"
+    FSCK_ALIAS_CYCLE = "alias-cycle"
+)
+
+type FsckIssue struct {
+    Kind string
+    Id string `json:",omitempty"`
+    Name string `json:",omitempty"`
+    Detail string
+    Fixed bool
+}
+
+type FsckReport struct {
+    Issues []FsckIssue
+    Unfixed int
+}
+
+func (issue FsckIssue) String() string {
+    res := fmt.Sprintf("%-20s", issue.Kind)
+    res += " " + issue.Name
+    if issue.Id != "" {
+        res += "(" + issue.Id + ")"
+    }
+    res += ": " + issue.Detail
+    if issue.Fixed {
+        res += ", fixed"
+    }
+    return res
+}
+
+func sortedNodeIds(data *GaiaData) []string {
+    ids := []string{}
+    for id := range data.NodeMap {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    return ids
+}
+
+func sortedKeys(m map[string]string) []string {
+    keys := []string{}
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// fsckData checks data, and fixes it when repair is set.
+func fsckData(data *GaiaData, repair bool, fileExists func(string) bool) []FsckIssue {
+    initGaiaData(data)
+    issues := []FsckIssue{}
+    report := func(kind string, node Node, detail string) {
+        issues = append(issues, FsckIssue{kind, node.Id, node.Name, detail, repair})
+    }
+
+    for _, id := range sortedNodeIds(data) {
+        node := data.NodeMap[id]
+        if node.Id != id {
+            report(FSCK_NODE_ID, node, "stored under id " + id)
+            node.Id = id
+        }
+        if category := strings.Split(node.Name, "-")[0]; node.Category != category {
+            report(FSCK_CATEGORY, node, "category is " + node.Category + ", expect " + category)
+            node.Category = category
+        }
+        if repair {
+            data.NodeMap[id] = node
+        }
+    }
+
+    // names: the map is rebuilt from the nodes, later ids of a name are renamed.
+    names := make(map[string]string)
+    for _, id := range sortedNodeIds(data) {
+        node := data.NodeMap[id]
+        if other, taken := names[node.Name]; taken {
+            report(FSCK_DUPLICATE_NAME, node, "name is also used by " + other)
+            if repair {
+                node.Name = renameForConflict(node.Name, func(name string) bool { return names[name] != "" })
+                data.NodeMap[id] = node
+            }
+        }
+        names[node.Name] = id
+    }
+    for _, name := range sortedKeys(data.NameIdMap) {
+        id := data.NameIdMap[name]
+        if names[name] != id {
+            report(FSCK_NAME_MAP, Node{Id: id, Name: name}, "name map entry without such node")
+        }
+    }
+    for _, name := range sortedKeys(names) {
+        if _, exist := data.NameIdMap[name]; !exist {
+            report(FSCK_NAME_MAP, Node{Id: names[name], Name: name}, "node missing in name map")
+        }
+    }
+    if repair {
+        data.NameIdMap = names
+    }
+
+    // prefixes
+    used := make(map[string]bool)
+    for _, node := range data.NodeMap {
+        parts := strings.Split(node.Name, "-")
+        used[parts[0]] = true
+        if len(parts) > 1 {
+            used[parts[0] + "-" + parts[1]] = true
+        }
+    }
+    for _, m := range []map[string]string{data.CategoryIdMap, data.BranchIdMap} {
+        owner := make(map[string]string)
+        for _, name := range sortedKeys(m) {
+            prefix := m[name]
+            switch {
+            case !used[name]:
+                report(FSCK_PREFIX_MAP, Node{Name: name}, "prefix " + prefix + " without nodes")
+            case owner[prefix] != "":
+                report(FSCK_PREFIX_MAP, Node{Name: name}, "prefix " + prefix + " is also given to " + owner[prefix])
+            case strings.Contains(name, "-") && !strings.HasPrefix(prefix, data.CategoryIdMap[strings.Split(name, "-")[0]]):
+                report(FSCK_PREFIX_MAP, Node{Name: name}, "branch prefix " + prefix + " is outside its category")
+            default:
+                owner[prefix] = name
+                continue
+            }
+            if repair {
+                delete(m, name)
+            }
+        }
+    }
+    if repair {
+        adoptPrefixes(data)
+    }
+
+    store := &JsonFileStore{"", data}
+    remap := make(map[string]string)
+    for _, id := range sortedNodeIds(data) {
+        node := data.NodeMap[id]
+        if idFits(node, id, data) {
+            continue
+        }
+        report(FSCK_ID_PREFIX, node, "id does not start with the prefix of its category or branch")
+        if !repair {
+            continue
+        }
+        delete(data.NodeMap, id)
+        newId, err := store.generateId(node.Name)
+        if err != nil {
+            data.NodeMap[id] = node
+            issues[len(issues) - 1].Fixed = false
+            issues[len(issues) - 1].Detail += ", " + err.Error()
+            continue
+        }
+        node.Id = newId
+        data.NodeMap[newId] = node
+        data.NameIdMap[node.Name] = newId
+        if state, ok := data.CardMap[id]; ok {
+            delete(data.CardMap, id)
+            data.CardMap[newId] = state
+        }
+        remap[id] = newId
+        issues[len(issues) - 1].Detail += ", new id " + newId
+    }
+
+    for _, id := range sortedNodeIds(data) {
+        node := data.NodeMap[id]
+        links := []string{}
+        for _, link := range splitList(node.Links) {
+            if remap[link] != "" {
+                link = remap[link]
+            }
+            if _, exist := data.NodeMap[link]; !exist {
+                report(FSCK_DANGLING_LINK, node, "link to missing node " + link)
+                continue
+            }
+            links = append(links, link)
+        }
+        attachments := []string{}
+        for _, path := range node.Attachments {
+            if !fileExists(path) {
+                report(FSCK_DANGLING_ATTACHMENT, node, "attachment missing: " + path)
+                continue
+            }
+            attachments = append(attachments, path)
+        }
+        if repair {
+            node.Links = strings.Join(links, ",")
+            if len(attachments) == 0 {
+                attachments = nil
+            }
+            node.Attachments = attachments
+            data.NodeMap[id] = node
+        }
+    }
+
+    cardIds := []string{}
+    for id := range data.CardMap {
+        cardIds = append(cardIds, id)
+    }
+    sort.Strings(cardIds)
+    for _, id := range cardIds {
+        if _, exist := data.NodeMap[id]; !exist {
+            report(FSCK_ORPHAN_CARD, Node{Id: id}, "review schedule of a missing node")
+            if repair {
+                delete(data.CardMap, id)
+            }
+        }
+    }
+
+    for _, from := range sortedKeys(data.AliasMap) {
+        seen := map[string]bool{from: true}
+        path := []string{from}
+        for to, ok := data.AliasMap[from]; ok; to, ok = data.AliasMap[to] {
+            path = append(path, to)
+            if !seen[to] {
+                seen[to] = true
+                continue
+            }
+            if to == from && len(path) == 2 {
+                report(FSCK_ALIAS_CYCLE, Node{Name: from}, "alias to itself")
+                if repair {
+                    delete(data.AliasMap, from)
+                }
+            } else if to == from && from == minString(path) {
+                // reported once, by its first alias.
+                issues = append(issues, FsckIssue{FSCK_ALIAS_CYCLE, "", from, "alias cycle " + strings.Join(path, " -> "), false})
+            }
+            break
+        }
+    }
+    return issues
+}
+
+func minString(arr []string) string {
+    res := arr[0]
+    for _, s := range arr {
+        if s < res {
+            res = s
+        }
+    }
+    return res
+}
+
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+func (jsonStore *JsonFileStore) Fsck(repair bool) ([]FsckIssue, error) {
+    issues := fsckData(jsonStore.gaiaData, repair, fileExists)
+    if repair && len(issues) > 0 {
+        return issues, jsonStore.saveToFile()
+    }
+    return issues, nil
+}
+
+func (gitStore *GitStore) Fsck(repair bool) ([]FsckIssue, error) {
+    issues, err := gitStore.JsonFileStore.Fsck(repair)
+    if err == nil && repair && len(issues) > 0 {
+        err = gitStore.commit(fmt.Sprintf("fsck: repair %d issues", len(issues)))
+    }
+    return issues, err
+}
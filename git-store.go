@@ -0,0 +1,504 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// GitStore keeps the nodes in a git working tree, one markdown file per
+// node, and commits every change:
+//   nodes/<name>.md     the node as `gaia edit` shows it
+//   aliases.json        the alias map
+// Files are named by node name, so nodes added on two clones never touch
+// the same file, ids that collide after a merge, or that lost their prefix
+// to a category of the other clone, are issued anew on load.
+// Card review schedules and node usage are personal and stay in
+// .git/gaia-cards.json and .git/gaia-usage.json.
+// `gaia sync` merges with the remote, see Sync.
+type GitStore struct {
+    *JsonFileStore // in memory, FilePath is empty
+    Dir string
+    Remote string
+    Branch string
+}
+
+const (
+    gitNodesDir = "nodes"
+    gitAliasFile = "aliases.json"
+    gitCardsFile = "gaia-cards.json" // below .git
+    gitUsageFile = "gaia-usage.json" // below .git
+    defaultGitRemote = "origin"
+    defaultGitBranch = "main"
+)
+
+var conflictMarkers = []string{"<<<<<<< ", "=======", ">>>>>>> "}
+
+// GitConfig turns the git store on, e.g.
+//   "Git": { "Dir": "/home/ann/notes", "Remote": "origin", "Branch": "main" }
+type GitConfig struct {
+    Dir string
+    Remote string
+    Branch string
+}
+
+func newGitStore(config GitConfig) (*GitStore, error) {
+    gitStore := &GitStore{&JsonFileStore{"", &GaiaData{}}, config.Dir, config.Remote, config.Branch}
+    if gitStore.Remote == "" {
+        gitStore.Remote = defaultGitRemote
+    }
+    if gitStore.Branch == "" {
+        gitStore.Branch = defaultGitBranch
+    }
+    if _, err := os.Stat(filepath.Join(gitStore.Dir, ".git")); err != nil {
+        return nil, newCodedError(ERR_NOT_FOUND, "no git working tree at " + gitStore.Dir + ", create it with: gaia sync --init [<url>]")
+    }
+    return gitStore, gitStore.load()
+}
+
+func (gitStore *GitStore) git(args ...string) (string, error) {
+    cmd := exec.Command("git", append([]string{"-C", gitStore.Dir}, args...)...)
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return string(out), errors.New("git " + args[0] + ": " + strings.TrimSpace(stderr.String() + " " + string(out)))
+    }
+    return strings.TrimSpace(string(out)), nil
+}
+
+// load reads the tree into memory, the id maps are derived from the ids.
+func (gitStore *GitStore) load() error {
+    data := &GaiaData{
+        AliasMap: map[string]string{},
+        CategoryIdMap: map[string]string{},
+        BranchIdMap: map[string]string{},
+        NameIdMap: map[string]string{},
+        NodeMap: map[string]Node{},
+        CardMap: map[string]CardState{},
+    }
+    gitStore.gaiaData = data
+
+    if bs, err := ioutil.ReadFile(filepath.Join(gitStore.Dir, gitAliasFile)); err == nil {
+        if err := json.Unmarshal(bs, &data.AliasMap); err != nil {
+            return errors.New(gitAliasFile + ": " + err.Error())
+        }
+    }
+    if bs, err := ioutil.ReadFile(filepath.Join(gitStore.Dir, ".git", gitCardsFile)); err == nil {
+        json.Unmarshal(bs, &data.CardMap)
+    }
+
+    files, _ := filepath.Glob(filepath.Join(gitStore.Dir, gitNodesDir, "*.md"))
+    sort.Strings(files)
+    nodes := []Node{}
+    for _, file := range files {
+        bs, err := ioutil.ReadFile(file)
+        if err != nil {
+            return err
+        }
+        node := Node{}
+        if err := (&node).ParseMarkdown(string(bs)); err != nil {
+            return errors.New(file + ": " + err.Error())
+        }
+        if _, err := nodeFileName(node.Name); err != nil {
+            return errors.New(file + ": " + err.Error())
+        }
+        node.Category = strings.Split(node.Name, "-")[0]
+        nodes = append(nodes, node)
+    }
+
+    // ids two clones gave out both are issued anew, so are ids that do not
+    // fit their category or branch after clones gave a prefix to two names.
+    reissue := []Node{}
+    for _, node := range nodes {
+        if _, taken := data.NodeMap[node.Id]; taken || node.Id == "" {
+            reissue = append(reissue, node)
+            continue
+        }
+        data.NodeMap[node.Id] = node
+        data.NameIdMap[node.Name] = node.Id
+    }
+    applyNodeUsage(data, readNodeUsage(filepath.Join(gitStore.Dir, ".git", gitUsageFile)))
+    adoptPrefixes(data)
+    misfits := make(map[string]bool)
+    for _, id := range sortedNodeIds(data) {
+        node := data.NodeMap[id]
+        if !idFits(node, id, data) {
+            delete(data.NodeMap, id)
+            delete(data.NameIdMap, node.Name)
+            misfits[id] = true
+            reissue = append(reissue, node)
+        }
+    }
+    if len(reissue) == 0 {
+        return nil
+    }
+
+    remap := make(map[string]string) // of misfits, their old id is unique
+    for _, node := range reissue {
+        oldId := node.Id
+        id, err := gitStore.generateId(node.Name)
+        if err != nil {
+            return err
+        }
+        node.Id = id
+        data.NodeMap[id] = node
+        data.NameIdMap[node.Name] = id
+        if state, ok := data.CardMap[oldId]; ok && oldId != "" {
+            data.CardMap[id] = state
+        }
+        if misfits[oldId] {
+            remap[oldId] = id
+            delete(data.CardMap, oldId)
+        }
+    }
+    for id, node := range data.NodeMap {
+        links := []string{}
+        for _, link := range splitList(node.Links) {
+            if remap[link] != "" {
+                link = remap[link]
+            }
+            links = append(links, link)
+        }
+        node.Links = strings.Join(links, ",")
+        data.NodeMap[id] = node
+    }
+    return gitStore.commit(fmt.Sprintf("issue new ids for %d nodes", len(reissue)))
+}
+
+func nodeFileName(name string) (string, error) {
+    if err := checkNodeName(name); err != nil {
+        return "", err
+    }
+    file := filepath.Join(gitNodesDir, name + ".md")
+    if filepath.Dir(file) != gitNodesDir {
+        return "", newCodedError(ERR_INVALID, "invalid node name " + name)
+    }
+    return file, nil
+}
+
+// writeTree syncs the files with memory, nodes removed or renamed leave
+// their file behind otherwise.
+func (gitStore *GitStore) writeTree() error {
+    if err := os.MkdirAll(filepath.Join(gitStore.Dir, gitNodesDir), 0755); err != nil {
+        return err
+    }
+    keep := make(map[string]bool)
+    for _, node := range gitStore.gaiaData.NodeMap {
+        name, err := nodeFileName(node.Name)
+        if err != nil {
+            return err
+        }
+        file := filepath.Join(gitStore.Dir, name)
+        keep[file] = true
+        if err := ioutil.WriteFile(file, []byte(node.Markdown()), 0644); err != nil {
+            return err
+        }
+    }
+    files, _ := filepath.Glob(filepath.Join(gitStore.Dir, gitNodesDir, "*.md"))
+    for _, file := range files {
+        if !keep[file] {
+            os.Remove(file)
+        }
+    }
+
+    bs, err := json.MarshalIndent(gitStore.gaiaData.AliasMap, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := ioutil.WriteFile(filepath.Join(gitStore.Dir, gitAliasFile), append(bs, '\n'), 0644); err != nil {
+        return err
+    }
+    bs, _ = json.MarshalIndent(gitStore.gaiaData.CardMap, "", "  ")
+    if err := ioutil.WriteFile(filepath.Join(gitStore.Dir, ".git", gitCardsFile), bs, 0644); err != nil {
+        return err
+    }
+    return gitStore.writeUsage()
+}
+
+func (gitStore *GitStore) writeUsage() error {
+    return writeNodeUsage(filepath.Join(gitStore.Dir, ".git", gitUsageFile), gitStore.gaiaData)
+}
+
+// gitCommit runs a command that commits, with a stand-in identity when
+// git has none configured.
+func (gitStore *GitStore) gitCommit(args ...string) (string, error) {
+    if name, _ := gitStore.git("config", "user.name"); name == "" {
+        args = append([]string{"-c", "user.name=gaia", "-c", "user.email=gaia@localhost"}, args...)
+    }
+    return gitStore.git(args...)
+}
+
+// commit writes the tree and commits it when anything changed. Only the
+// files of the store are staged, others in the tree stay out of it.
+func (gitStore *GitStore) commit(message string) error {
+    if err := gitStore.writeTree(); err != nil {
+        return err
+    }
+    paths := []string{"--", gitNodesDir, gitAliasFile}
+    if _, err := gitStore.git(append([]string{"add", "-A"}, paths...)...); err != nil {
+        return err
+    }
+    if status, err := gitStore.git(append([]string{"status", "--porcelain"}, paths...)...); err != nil || status == "" {
+        return err
+    }
+    _, err := gitStore.gitCommit(append([]string{"commit", "-q", "-m", message}, paths...)...)
+    return err
+}
+
+func (gitStore *GitStore) Add(node Node) (string, error) {
+    id, err := gitStore.JsonFileStore.Add(node)
+    if err != nil {
+        return id, err
+    }
+    added, _ := gitStore.GetById(id)
+    return id, gitStore.commit("add " + added.Name + " (" + id + ")")
+}
+
+func (gitStore *GitStore) AddAlias(from, to string) error {
+    if err := gitStore.JsonFileStore.AddAlias(from, to); err != nil {
+        return err
+    }
+    return gitStore.commit("alias " + from + " -> " + to)
+}
+
+func (gitStore *GitStore) RemoveAlias(keyword string) error {
+    if err := gitStore.JsonFileStore.RemoveAlias(keyword); err != nil {
+        return err
+    }
+    return gitStore.commit("remove alias " + keyword)
+}
+
+func (gitStore *GitStore) Update(node Node) error {
+    if err := gitStore.JsonFileStore.Update(node); err != nil {
+        return err
+    }
+    updated, _ := gitStore.GetById(node.Id)
+    return gitStore.commit("update " + updated.Name + " (" + node.Id + ")")
+}
+
+func (gitStore *GitStore) Append(id string, extraContent string) error {
+    if err := gitStore.JsonFileStore.Append(id, extraContent); err != nil {
+        return err
+    }
+    node, _ := gitStore.GetById(id)
+    return gitStore.commit("append to " + node.Name + " (" + id + ")")
+}
+
+func (gitStore *GitStore) Remove(id string) error {
+    node, err := gitStore.GetById(id)
+    if err != nil {
+        return err
+    }
+    if err := gitStore.JsonFileStore.Remove(id); err != nil {
+        return err
+    }
+    return gitStore.commit("remove " + node.Name + " (" + id + ")")
+}
+
+func (gitStore *GitStore) SetCardState(id string, state CardState) error {
+    if err := gitStore.JsonFileStore.SetCardState(id, state); err != nil {
+        return err
+    }
+    return gitStore.writeTree()
+}
+
+// Touch is not committed, usage is kept like the card schedules.
+func (gitStore *GitStore) Touch(id string) error {
+    if err := gitStore.JsonFileStore.Touch(id); err != nil {
+        return err
+    }
+    return gitStore.writeUsage()
+}
+
+func (gitStore *GitStore) FormatData() error {
+    if err := gitStore.JsonFileStore.FormatData(); err != nil {
+        return err
+    }
+    return gitStore.commit("format data")
+}
+
+func (gitStore *GitStore) ReorgAllData() error {
+    if err := gitStore.JsonFileStore.ReorgAllData(); err != nil {
+        return err
+    }
+    return gitStore.commit("reorg data")
+}
+
+// initGitStore creates the working tree: a clone of url when it has
+// commits, else a new repository holding the nodes of from.
+func initGitStore(config GitConfig, url string, from Store) (*GitStore, error) {
+    if _, err := os.Stat(filepath.Join(config.Dir, ".git")); err == nil {
+        return nil, newCodedError(ERR_CONFLICT, "git working tree exists: " + config.Dir)
+    }
+    branch := config.Branch
+    if branch == "" {
+        branch = defaultGitBranch
+    }
+    if url != "" {
+        if out, err := exec.Command("git", "ls-remote", "--heads", url, branch).Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+            if out, err := exec.Command("git", "clone", "-q", "-b", branch, url, config.Dir).CombinedOutput(); err != nil {
+                return nil, errors.New("git clone: " + strings.TrimSpace(string(out)))
+            }
+            return newGitStore(config)
+        }
+    }
+
+    if err := os.MkdirAll(config.Dir, 0755); err != nil {
+        return nil, err
+    }
+    if out, err := exec.Command("git", "init", "-q", config.Dir).CombinedOutput(); err != nil {
+        return nil, errors.New("git init: " + strings.TrimSpace(string(out)))
+    }
+    gitStore := &GitStore{&JsonFileStore{"", &GaiaData{}}, config.Dir, config.Remote, branch}
+    if gitStore.Remote == "" {
+        gitStore.Remote = defaultGitRemote
+    }
+    if _, err := gitStore.git("checkout", "-q", "-b", branch); err != nil {
+        return nil, err
+    }
+    if url != "" {
+        if _, err := gitStore.git("remote", "add", gitStore.Remote, url); err != nil {
+            return nil, err
+        }
+    }
+    if err := gitStore.load(); err != nil {
+        return nil, err
+    }
+    for _, node := range from.ListNodes([]string{}) {
+        gitStore.gaiaData.NodeMap[node.Id] = node
+        gitStore.gaiaData.NameIdMap[node.Name] = node.Id
+    }
+    for k, v := range from.GetAlias() {
+        gitStore.gaiaData.AliasMap[k] = v
+    }
+    for k, v := range from.GetCardStates() {
+        gitStore.gaiaData.CardMap[k] = v
+    }
+    if err := gitStore.commit(fmt.Sprintf("import %d nodes", len(gitStore.gaiaData.NodeMap))); err != nil {
+        return nil, err
+    }
+    return gitStore, gitStore.load()
+}
+
+// SyncReport tells what `gaia sync` did.
+type SyncReport struct {
+    Pulled bool // the remote had commits that were merged
+    Resolved []string // files resolved in the editor
+    Pushed bool
+}
+
+// Sync commits stray edits of the tree, merges the remote branch, opens
+// every conflicted file in the editor and pushes the result.
+func (gitStore *GitStore) Sync(editor string) (SyncReport, error) {
+    report := SyncReport{}
+    if err := gitStore.commit("sync local changes"); err != nil {
+        return report, err
+    }
+    if _, err := gitStore.git("remote", "get-url", gitStore.Remote); err != nil {
+        return report, newCodedError(ERR_NOT_FOUND, "no remote " + gitStore.Remote + " in " + gitStore.Dir)
+    }
+    if _, err := gitStore.git("fetch", "-q", gitStore.Remote); err != nil {
+        return report, err
+    }
+
+    remoteRef := gitStore.Remote + "/" + gitStore.Branch
+    if _, err := gitStore.git("rev-parse", "--verify", "-q", remoteRef); err == nil {
+        behind, _ := gitStore.git("rev-list", "--count", "HEAD.." + remoteRef)
+        report.Pulled = behind != "0"
+        if report.Pulled {
+            if err := gitStore.merge(remoteRef, editor, &report); err != nil {
+                return report, err
+            }
+        }
+    }
+
+    if err := gitStore.load(); err != nil {
+        return report, err
+    }
+    ahead, _ := gitStore.git("rev-list", "--count", remoteRef + "..HEAD")
+    if ahead != "0" {
+        if _, err := gitStore.git("push", "-q", gitStore.Remote, "HEAD:" + gitStore.Branch); err != nil {
+            return report, err
+        }
+        report.Pushed = true
+    }
+    return report, nil
+}
+
+func (gitStore *GitStore) merge(ref string, editor string, report *SyncReport) error {
+    if _, err := gitStore.gitCommit("merge", "-q", "--no-edit", "-m", "merge " + ref, ref); err == nil {
+        return nil
+    }
+
+    conflicted, err := gitStore.git("diff", "--name-only", "--diff-filter=U")
+    if err != nil || conflicted == "" {
+        gitStore.git("merge", "--abort")
+        return errors.New("merge of " + ref + " failed: " + fmt.Sprint(err))
+    }
+    for _, file := range strings.Split(conflicted, "\n") {
+        if err := resolveInEditor(editor, filepath.Join(gitStore.Dir, file)); err != nil {
+            gitStore.git("merge", "--abort")
+            return err
+        }
+        if _, err := gitStore.git("add", "--", file); err != nil {
+            return err
+        }
+        report.Resolved = append(report.Resolved, file)
+    }
+    _, err = gitStore.gitCommit("commit", "-q", "--no-edit")
+    return err
+}
+
+// resolveInEditor reopens a conflicted file until its markers are gone
+// and it parses again, saving it unchanged aborts the merge.
+func resolveInEditor(editor string, file string) error {
+    bs, err := ioutil.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    last := string(bs)
+    for {
+        if err := runEditor(editor, file); err != nil {
+            return err
+        }
+        bs, err := ioutil.ReadFile(file)
+        if err != nil {
+            return err
+        }
+        text := string(bs)
+        if text == last {
+            return newCodedError(ERR_CONFLICT, "conflict in " + file + " is not resolved, merge aborted")
+        }
+        err = checkResolved(file, text)
+        if err == nil {
+            return nil
+        }
+        last = text
+        fmt.Fprintln(os.Stderr, file + ": " + err.Error() + ", opening it again")
+    }
+}
+
+func checkResolved(file string, text string) error {
+    for _, line := range strings.Split(text, "\n") {
+        for _, marker := range conflictMarkers {
+            if strings.HasPrefix(line, marker) || line == strings.TrimSpace(marker) {
+                return errors.New("conflict marker left: " + line)
+            }
+        }
+    }
+    if strings.HasSuffix(file, ".md") {
+        return (&Node{}).ParseMarkdown(text)
+    }
+    if strings.HasSuffix(file, ".json") {
+        return json.Unmarshal([]byte(text), &map[string]string{})
+    }
+    return nil
+}
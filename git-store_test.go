@@ -0,0 +1,100 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func nodeByName(t *testing.T, store Store, name string) Node {
+    for _, node := range store.ListNodes([]string{name}) {
+        if node.Name == name {
+            return node
+        }
+    }
+    t.Fatalf("no node %s", name)
+    return Node{}
+}
+
+// two clones give the same category prefix to different categories, sync
+// through a bare repository issues new ids to one of them on both sides.
+func TestGitStoreSyncClones(t *testing.T) {
+    if _, err := exec.LookPath("git"); err != nil {
+        t.Skip("no git")
+    }
+    dir, err := ioutil.TempDir("", "gaia-git-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    remote := filepath.Join(dir, "remote.git")
+    if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+        t.Fatal(string(out))
+    }
+
+    from := &GaiaData{}
+    initGaiaData(from)
+    from.NodeMap["0000"] = Node{Id: "0000", Name: "os-sh-a", Category: "os", Content: "a"}
+    from.NameIdMap["os-sh-a"] = "0000"
+    a, err := initGitStore(GitConfig{Dir: filepath.Join(dir, "a")}, remote, &JsonFileStore{"", from})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := a.Sync(""); err != nil {
+        t.Fatal(err)
+    }
+    b, err := initGitStore(GitConfig{Dir: filepath.Join(dir, "b")}, remote, &JsonFileStore{"", &GaiaData{}})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // a: go gets prefix 1, b: py gets prefix 1 too.
+    if _, err := a.Add(Node{Name: "go-x", Content: "go"}); err != nil {
+        t.Fatal(err)
+    }
+    pyB, err := b.Add(Node{Name: "py-a-b", Content: "b"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := b.Add(Node{Name: "py-a-c", Content: "c", Links: pyB}); err != nil {
+        t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(filepath.Join(a.Dir, "stray.txt"), []byte("not a node"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, store := range []*GitStore{a, b, a} {
+        if _, err := store.Sync(""); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for _, store := range []*GitStore{a, b} {
+        if issues := fsckData(store.gaiaData, false, func(string) bool { return true }); len(issues) > 0 {
+            t.Errorf("%s: fsck issues %v", store.Dir, issues)
+        }
+        if n := len(store.ListNodes([]string{})); n != 4 {
+            t.Errorf("%s: %d nodes, want 4", store.Dir, n)
+        }
+        goX, pyB, pyC := nodeByName(t, store, "go-x"), nodeByName(t, store, "py-a-b"), nodeByName(t, store, "py-a-c")
+        if goX.Id[:1] == pyB.Id[:1] {
+            t.Errorf("%s: go-x(%s) and py-a-b(%s) share a prefix", store.Dir, goX.Id, pyB.Id)
+        }
+        if pyC.Links != pyB.Id {
+            t.Errorf("%s: py-a-c links %s, want %s", store.Dir, pyC.Links, pyB.Id)
+        }
+    }
+    if nodeByName(t, a, "py-a-b").Id != nodeByName(t, b, "py-a-b").Id {
+        t.Errorf("clones disagree on the id of py-a-b")
+    }
+    files, _ := a.git("ls-files")
+    if strings.Contains(files, "stray.txt") {
+        t.Errorf("stray file committed: %s", files)
+    }
+    if status, _ := b.git("status", "--porcelain"); status != "" {
+        t.Errorf("b left changes: %s", status)
+    }
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+)
+
+// token kinds of highlightCode, an empty kind is plain text.
+const (
+    TOKEN_KEYWORD = "kw"
+    TOKEN_STRING = "str"
+    TOKEN_COMMENT = "com"
+    TOKEN_NUMBER = "num"
+)
+
+type codeToken struct {
+    Kind string
+    Text string
+}
+
+type langSyntax struct {
+    lineComments []string
+    blockComment [2]string
+    quotes string
+    keywords []string
+}
+
+var cLikeKeywords = []string{
+    "break", "case", "catch", "class", "const", "continue", "default", "do", "else",
+    "extends", "false", "final", "finally", "for", "if", "import", "new", "null",
+    "package", "private", "protected", "public", "return", "static", "super",
+    "switch", "this", "throw", "true", "try", "void", "while",
+}
+
+var langSyntaxMap = map[string]langSyntax{
+    "javascript": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'`",
+        append([]string{"async", "await", "export", "from", "function", "let", "typeof", "undefined", "var", "yield"}, cLikeKeywords...)},
+    "typescript": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'`",
+        append([]string{"async", "await", "export", "from", "function", "interface", "let", "type", "undefined", "var"}, cLikeKeywords...)},
+    "java": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'",
+        append([]string{"abstract", "boolean", "byte", "char", "double", "enum", "float", "implements", "int", "interface", "long", "synchronized", "throws"}, cLikeKeywords...)},
+    "scala": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'",
+        append([]string{"abstract", "def", "implicit", "lazy", "match", "object", "override", "sealed", "trait", "type", "val", "var", "with", "yield"}, cLikeKeywords...)},
+    "go": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'`",
+        []string{"break", "case", "chan", "const", "continue", "default", "defer", "else", "false", "for", "func", "go", "if", "import", "interface", "map", "nil", "package", "range", "return", "select", "struct", "switch", "true", "type", "var"}},
+    "c": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'",
+        []string{"char", "const", "double", "else", "enum", "float", "for", "if", "int", "long", "return", "sizeof", "static", "struct", "typedef", "unsigned", "void", "while"}},
+    "sol": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'",
+        []string{"address", "bool", "contract", "else", "emit", "event", "external", "for", "function", "if", "internal", "mapping", "memory", "modifier", "payable", "public", "pure", "require", "return", "returns", "storage", "uint", "uint256", "view"}},
+    "python": {[]string{"#"}, [2]string{}, "\"'",
+        []string{"and", "as", "class", "def", "elif", "else", "except", "False", "for", "from", "if", "import", "in", "is", "lambda", "None", "not", "or", "pass", "raise", "return", "True", "try", "while", "with", "yield"}},
+    "sh": {[]string{"#"}, [2]string{}, "\"'",
+        []string{"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function", "if", "in", "local", "then", "while"}},
+    "ruby": {[]string{"#"}, [2]string{}, "\"'",
+        []string{"class", "def", "do", "else", "elsif", "end", "false", "if", "module", "nil", "require", "return", "self", "true", "unless", "while", "yield"}},
+    "yaml": {[]string{"#"}, [2]string{}, "\"'", []string{"true", "false", "null"}},
+    "sql": {[]string{"--"}, [2]string{"/*", "*/"}, "'",
+        []string{"and", "by", "create", "delete", "from", "group", "insert", "into", "join", "not", "null", "on", "or", "order", "select", "set", "table", "update", "values", "where"}},
+}
+
+var langAliasMap = map[string]string{
+    "js": "javascript",
+    "ts": "typescript",
+    "cpp": "c",
+    "h": "c",
+    "bash": "sh",
+    "zsh": "sh",
+    "py": "python",
+    "rb": "ruby",
+    "yml": "yaml",
+}
+
+// nodeLanguage guesses the content language from ExecFile, then from the
+// tags, the empty string means prose.
+func nodeLanguage(node Node) string {
+    if lang := fenceLanguage(node); lang != "" {
+        return lang
+    }
+    for _, tag := range splitList(node.Tags) {
+        if ext, ok := tagExtMap[strings.ToLower(tag)]; ok {
+            return fenceLanguage(Node{ExecFile: "x" + ext})
+        }
+    }
+    return ""
+}
+
+// highlightCode splits code into lines of tokens. It knows comments,
+// strings, numbers and keywords, which is enough for printed snippets.
+func highlightCode(code string, lang string) [][]codeToken {
+    lang = strings.ToLower(lang)
+    if alias, ok := langAliasMap[lang]; ok {
+        lang = alias
+    }
+    syntax, known := langSyntaxMap[lang]
+
+    lines := [][]codeToken{}
+    inBlock := false
+    for _, line := range strings.Split(code, "\n") {
+        line = strings.Replace(line, "\t", "    ", -1)
+        if !known {
+            lines = append(lines, []codeToken{{"", line}})
+            continue
+        }
+        var tokens []codeToken
+        tokens, inBlock = highlightLine(line, syntax, inBlock)
+        lines = append(lines, tokens)
+    }
+    return lines
+}
+
+func highlightLine(line string, syntax langSyntax, inBlock bool) ([]codeToken, bool) {
+    tokens := []codeToken{}
+    push := func(kind, text string) {
+        if text == "" {
+            return
+        }
+        if len(tokens) > 0 && tokens[len(tokens) - 1].Kind == kind {
+            tokens[len(tokens) - 1].Text += text
+            return
+        }
+        tokens = append(tokens, codeToken{kind, text})
+    }
+
+    rs := []rune(line)
+    i := 0
+    for i < len(rs) {
+        rest := string(rs[i:])
+        if inBlock {
+            end := strings.Index(rest, syntax.blockComment[1])
+            if end < 0 {
+                push(TOKEN_COMMENT, rest)
+                return tokens, true
+            }
+            end += len(syntax.blockComment[1])
+            push(TOKEN_COMMENT, rest[:end])
+            i += len([]rune(rest[:end]))
+            inBlock = false
+            continue
+        }
+
+        if syntax.blockComment[0] != "" && strings.HasPrefix(rest, syntax.blockComment[0]) {
+            inBlock = true
+            push(TOKEN_COMMENT, syntax.blockComment[0])
+            i += len([]rune(syntax.blockComment[0]))
+            continue
+        }
+
+        isLineComment := false
+        for _, prefix := range syntax.lineComments {
+            if strings.HasPrefix(rest, prefix) {
+                isLineComment = true
+            }
+        }
+        if isLineComment {
+            push(TOKEN_COMMENT, rest)
+            break
+        }
+
+        r := rs[i]
+        switch {
+        case strings.ContainsRune(syntax.quotes, r):
+            j := i + 1
+            for j < len(rs) && rs[j] != r {
+                if rs[j] == '\\' {
+                    j++
+                }
+                j++
+            }
+            if j >= len(rs) {
+                j = len(rs) - 1
+            }
+            push(TOKEN_STRING, string(rs[i:j + 1]))
+            i = j + 1
+        case unicode.IsDigit(r):
+            j := i
+            for j < len(rs) && (unicode.IsDigit(rs[j]) || unicode.IsLetter(rs[j]) || rs[j] == '.' || rs[j] == '_') {
+                j++
+            }
+            push(TOKEN_NUMBER, string(rs[i:j]))
+            i = j
+        case unicode.IsLetter(r) || r == '_':
+            j := i
+            for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+                j++
+            }
+            word := string(rs[i:j])
+            if ArrContains(syntax.keywords, word) {
+                push(TOKEN_KEYWORD, word)
+            } else {
+                push("", word)
+            }
+            i = j
+        default:
+            push("", string(r))
+            i++
+        }
+    }
+    return tokens, inBlock
+}
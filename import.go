@@ -0,0 +1,242 @@
+package main
+
+import (
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    NAME_FROM_PATH = "path"
+    NAME_FROM_FRONTMATTER = "frontmatter"
+    NAME_FROM_HEADING = "heading"
+)
+
+const (
+    CONFLICT_SKIP = "skip"
+    CONFLICT_RENAME = "rename"
+    CONFLICT_OVERWRITE = "overwrite"
+)
+
+const maxImportFileSize = 1 << 20
+
+// extensions the executor knows how to run.
+var executableExts = []string{".js", ".ts", ".java", ".scala", ".go"}
+var textNoteExts = []string{".md", ".markdown", ".txt", ""}
+
+var hashtagRegexp = regexp.MustCompile(`(?:^|\s)#([A-Za-z][A-Za-z0-9_-]*)`)
+var headingRegexp = regexp.MustCompile(`(?m)^#{1,6}\s+(.+)$`)
+var namePartRegexp = regexp.MustCompile(`[^a-z0-9_.]+`)
+
+type ImportOptions struct {
+    Category string
+    NameFrom string
+    OnConflict string
+    DryRun bool
+}
+
+type ImportItem struct {
+    File string
+    Name string
+    Id string `json:"Id,omitempty"`
+    Status string // added, renamed, overwritten, skipped, failed
+    Reason string `json:"Reason,omitempty"`
+}
+
+type ImportReport struct {
+    Added int
+    Renamed int
+    Overwritten int
+    Skipped int
+    Failed int
+    Items []ImportItem
+}
+
+func (report *ImportReport) add(item ImportItem) {
+    switch item.Status {
+    case "added":
+        report.Added++
+    case "renamed":
+        report.Renamed++
+    case "overwritten":
+        report.Overwritten++
+    case "skipped":
+        report.Skipped++
+    case "failed":
+        report.Failed++
+    }
+    report.Items = append(report.Items, item)
+}
+
+func checkImportOptions(options ImportOptions) error {
+    if !ArrContains([]string{NAME_FROM_PATH, NAME_FROM_FRONTMATTER, NAME_FROM_HEADING}, options.NameFrom) {
+        return newCodedError(ERR_USAGE, "--name-from must be path, frontmatter or heading")
+    }
+    if !ArrContains([]string{CONFLICT_SKIP, CONFLICT_RENAME, CONFLICT_OVERWRITE}, options.OnConflict) {
+        return newCodedError(ERR_USAGE, "--on-conflict must be skip, rename or overwrite")
+    }
+    return nil
+}
+
+// listImportFiles walks dir for text files, hidden entries and build
+// output dirs are skipped. Paths are relative and sorted.
+func listImportFiles(dir string) ([]string, error) {
+    files := []string{}
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if path == dir {
+            return nil
+        }
+        hidden := strings.HasPrefix(info.Name(), ".")
+        if info.IsDir() {
+            if hidden || ArrContains(projectSkipDirs, info.Name()) {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if hidden || !info.Mode().IsRegular() || info.Size() > maxImportFileSize || info.Name() == exportIndexFile {
+            return nil
+        }
+
+        rel, err := filepath.Rel(dir, path)
+        if err != nil {
+            return err
+        }
+        files = append(files, filepath.ToSlash(rel))
+        return nil
+    })
+    sort.Strings(files)
+    return files, err
+}
+
+// nodeFromFile builds a node from one file below dir, the name is not
+// normalized yet.
+func nodeFromFile(dir string, rel string, options ImportOptions) (Node, error) {
+    bs, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+    if err != nil {
+        return Node{}, err
+    }
+    text := string(bs)
+    if strings.IndexByte(text, 0) >= 0 {
+        return Node{}, errors.New("binary file")
+    }
+
+    node := Node{}
+    ext := strings.ToLower(filepath.Ext(rel))
+    isNote := ArrContains(textNoteExts, ext)
+
+    body := text
+    if isNote {
+        if lines, rest, ok := splitFrontMatter(text); ok {
+            values, err := parseFrontMatter(lines, false)
+            if err != nil {
+                return node, err
+            }
+            body = rest
+            for key, v := range values {
+                switch key {
+                case "name":
+                    if options.NameFrom == NAME_FROM_FRONTMATTER {
+                        node.Name = v.scalar
+                    }
+                case "tags":
+                    node.Tags = strings.Join(v.list, ",")
+                case "desc":
+                    node.Desc = v.scalar
+                case "executable":
+                    node.Executable = v.scalar == "true"
+                case "exec_file":
+                    node.ExecFile = v.scalar
+                case "links":
+                    node.Links = strings.Join(v.list, ",")
+                }
+            }
+        }
+        if node.Tags == "" {
+            node.Tags = strings.Join(findHashtags(body), ",")
+        }
+        // code exported by `export md` is fenced.
+        if node.ExecFile != "" {
+            if code, ok := unfenceCode(body); ok {
+                body = code
+            }
+        }
+    }
+    node.Content = body
+
+    if !isNote && ArrContains(executableExts, ext) {
+        node.Executable = true
+        node.ExecFile = filepath.Base(rel)
+    }
+
+    dirParts := strings.Split(rel, "/")
+    dirParts = dirParts[:len(dirParts) - 1]
+    leaf := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+    if options.NameFrom == NAME_FROM_HEADING {
+        if m := headingRegexp.FindStringSubmatch(body); m != nil {
+            leaf = m[1]
+        }
+    }
+    if node.Name == "" {
+        node.Name = nameFromParts(append(dirParts, leaf))
+    }
+    if options.Category != "" {
+        node.Name = nameFromParts([]string{options.Category}) + "-" + node.Name
+    }
+    if node.Name == "" {
+        return node, errors.New("can not derive a name")
+    }
+    return node, nil
+}
+
+// nameFromParts joins path parts with hyphens, inside a part anything but
+// letters, digits, _ and . becomes _, so the parts keep the hierarchy.
+func nameFromParts(parts []string) string {
+    res := []string{}
+    for _, part := range parts {
+        part = namePartRegexp.ReplaceAllString(strings.ToLower(strings.TrimSpace(part)), "_")
+        part = strings.Trim(part, "_.")
+        if part != "" {
+            res = append(res, part)
+        }
+    }
+    return strings.Join(res, "-")
+}
+
+func findHashtags(text string) []string {
+    tags := []string{}
+    inCode := false
+    for _, line := range strings.Split(text, "\n") {
+        if strings.HasPrefix(strings.TrimSpace(line), "```") {
+            inCode = !inCode
+            continue
+        }
+        if inCode {
+            continue
+        }
+        for _, m := range hashtagRegexp.FindAllStringSubmatch(line, -1) {
+            tag := strings.ToLower(m[1])
+            if !ArrContains(tags, tag) {
+                tags = append(tags, tag)
+            }
+        }
+    }
+    return tags
+}
+
+// renameForConflict appends _2, _3 ... to the leaf until the name is free.
+func renameForConflict(name string, exists func(string) bool) string {
+    for i := 2; ; i++ {
+        candidate := name + "_" + strconv.Itoa(i)
+        if !exists(candidate) {
+            return candidate
+        }
+    }
+}
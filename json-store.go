@@ -6,15 +6,19 @@ import (
     "io/ioutil"
     "encoding/json"
     "errors"
+    "path/filepath"
     "strings"
+    "time"
 )
 
 type GaiaData struct {
+    Version int // layout of the file, see migrate.go
     AliasMap map[string]string
     CategoryIdMap map[string]string
     BranchIdMap map[string]string
     NameIdMap map[string]string // name -> id
     NodeMap map[string]Node  // id -> node map
+    CardMap map[string]CardState `json:",omitempty"` // id -> review schedule
 }
 
 type JsonFileStore struct {
@@ -22,34 +26,38 @@ type JsonFileStore struct {
     gaiaData *GaiaData
 }
 
-func newJsonFileStore(dataFilePath string) *JsonFileStore {
+func newJsonFileStore(dataFilePath string) (*JsonFileStore, error) {
     jsonStore := &JsonFileStore{dataFilePath, &GaiaData{}}
-    jsonStore.load()
-
-    return jsonStore
+    return jsonStore, jsonStore.load()
 }
 
-func (jsonStore *JsonFileStore) Add(node Node) error {
+func (jsonStore *JsonFileStore) Add(node Node) (string, error) {
     (&node).Normalize(jsonStore.gaiaData.AliasMap)
-    if node.Name == "" {
-        return errors.New("node name is empty")
+    if err := checkNodeName(node.Name); err != nil {
+        return "", err
     }
     if jsonStore.gaiaData.NameIdMap[node.Name] != "" {
-        return errors.New("node name exist:" + node.Name)
+        return "", newCodedError(ERR_CONFLICT, "node name exist:" + node.Name)
     }
 
     id, err := jsonStore.generateId(node.Name)
     if err != nil{
-        return err
+        return "", err
     }
 
-    fmt.Println("generate new node id:", id)
     node.Id = id
     node.Category = strings.Split(node.Name, "-")[0]
+    // Created and Author of imported nodes are kept, usage starts here.
+    now := time.Now()
+    if node.Created.IsZero() {
+        node.Created = now
+    }
+    node.Updated = now
+    node.LastAccessed, node.AccessCount = time.Time{}, 0
     jsonStore.gaiaData.NameIdMap[node.Name] = id
     jsonStore.gaiaData.NodeMap[id] = node
 
-    return jsonStore.saveToFile()
+    return id, jsonStore.saveToFile()
 }
 
 func (jsonStore *JsonFileStore) AddAlias(from, to string) error {
@@ -70,16 +78,31 @@ func (jsonStore *JsonFileStore) Update(node Node) error {
     (&node).Normalize(jsonStore.gaiaData.AliasMap)
     old, exist := jsonStore.gaiaData.NodeMap[node.Id]
     if !exist {
-        return errors.New("node with id" + node.Id + " is not exist")
+        return newCodedError(ERR_NOT_FOUND, "node with id" + node.Id + " is not exist")
+    }
+
+    if err := checkNodeName(node.Name); err != nil {
+        return err
     }
 
     oldBranch := old.GetBranch()
     newBranch := node.GetBranch()
 
     if oldBranch != newBranch {
-        return errors.New("can not do update, node's branch changed!")
+        return newCodedError(ERR_INVALID, "can not do update, node's branch changed!")
+    }
+    if other := jsonStore.gaiaData.NameIdMap[node.Name]; other != "" && other != node.Id {
+        return newCodedError(ERR_CONFLICT, "node name exist:" + node.Name)
     }
 
+    node.Category = old.Category
+    node.Created, node.Updated = old.Created, time.Now()
+    if old.Author != "" {
+        node.Author = old.Author
+    }
+    node.LastAccessed, node.AccessCount = old.LastAccessed, old.AccessCount
+    delete(jsonStore.gaiaData.NameIdMap, old.Name)
+    jsonStore.gaiaData.NameIdMap[node.Name] = node.Id
     jsonStore.gaiaData.NodeMap[node.Id] = node
     return jsonStore.saveToFile()
 }
@@ -87,11 +110,12 @@ func (jsonStore *JsonFileStore) Update(node Node) error {
 func (jsonStore *JsonFileStore) Append(id string, extraContent string) error {
     node, exist := jsonStore.gaiaData.NodeMap[id]
     if !exist {
-        return errors.New("node with id " + id + " not exists")
+        return newCodedError(ERR_NOT_FOUND, "node with id " + id + " not exists")
     }
 
     oldContent := strings.TrimSpace(node.Content)
     node.Content = oldContent + "\n\n" + strings.TrimSpace(extraContent)
+    node.Updated = time.Now()
     jsonStore.gaiaData.NodeMap[id] = node
 
     return jsonStore.saveToFile()
@@ -149,21 +173,31 @@ func (jsonStore *JsonFileStore) Search(category string, keywords []string) []Nod
 func (jsonStore *JsonFileStore) Remove(id string) error {
     node := jsonStore.gaiaData.NodeMap[id]
     delete(jsonStore.gaiaData.NodeMap, id)
+    delete(jsonStore.gaiaData.CardMap, id)
     name := node.Name
     delete(jsonStore.gaiaData.NameIdMap, name)
 
+    // free the prefixes of a category or branch that has no nodes left.
     parts := strings.Split(name, "-")
-    namePrefixExist := false
+    categoryUsed, branchUsed := false, false
     for n, _ := range jsonStore.gaiaData.NameIdMap {
-        if strings.HasPrefix(n, parts[0]) {
-            namePrefixExist = true
+        otherParts := strings.Split(n, "-")
+        if otherParts[0] != parts[0] {
+            continue
+        }
+        categoryUsed = true
+        if len(parts) > 1 && len(otherParts) > 1 && otherParts[1] == parts[1] {
+            branchUsed = true
             break
         }
     }
 
-    if !namePrefixExist {
+    if !categoryUsed {
         delete(jsonStore.gaiaData.CategoryIdMap, parts[0])
     }
+    if len(parts) > 1 && !branchUsed {
+        delete(jsonStore.gaiaData.BranchIdMap, parts[0] + "-" + parts[1])
+    }
 
     return jsonStore.saveToFile()
 }
@@ -172,10 +206,25 @@ func (jsonStore *JsonFileStore) GetById(id string) (Node, error) {
     if node, exist := jsonStore.gaiaData.NodeMap[id]; exist {
         return node, nil
     } else {
-        return Node{}, errors.New("Node with id " + id + " not found")
+        return Node{}, newCodedError(ERR_NOT_FOUND, "Node with id " + id + " not found")
     }
 }
 
+// Touch counts a use of the node by get or exec.
+func (jsonStore *JsonFileStore) Touch(id string) error {
+    node, exist := jsonStore.gaiaData.NodeMap[id]
+    if !exist {
+        return newCodedError(ERR_NOT_FOUND, "node with id " + id + " not exists")
+    }
+    node.LastAccessed = time.Now()
+    node.AccessCount++
+    jsonStore.gaiaData.NodeMap[id] = node
+    if jsonStore.FilePath == "" {
+        return nil
+    }
+    return writeNodeUsage(jsonStore.usagePath(), jsonStore.gaiaData)
+}
+
 func (jsonStore *JsonFileStore) GetStats() Stats {
     categories := []string{}
     tags := []string{}
@@ -205,6 +254,18 @@ func (jsonStore *JsonFileStore) GetAlias() map[string]string {
     return jsonStore.gaiaData.AliasMap
 }
 
+func (jsonStore *JsonFileStore) GetCardStates() map[string]CardState {
+    return jsonStore.gaiaData.CardMap
+}
+
+func (jsonStore *JsonFileStore) SetCardState(id string, state CardState) error {
+    if _, exist := jsonStore.gaiaData.NodeMap[id]; !exist {
+        return newCodedError(ERR_NOT_FOUND, "node with id " + id + " not exists")
+    }
+    jsonStore.gaiaData.CardMap[id] = state
+    return jsonStore.saveToFile()
+}
+
 func (jsonStore *JsonFileStore) ListCategories() map[string][]string {
     resultMap := make(map[string][]string)
     for name, id := range jsonStore.gaiaData.NameIdMap {
@@ -304,15 +365,24 @@ func (jsonStore *JsonFileStore) ReorgAllData() error {
 
     oldNodeMap := jsonStore.gaiaData.NodeMap
     jsonStore.gaiaData.NodeMap = map[string]Node{}
+    oldCardMap := jsonStore.gaiaData.CardMap
+    jsonStore.gaiaData.CardMap = map[string]CardState{}
 
-    for _, node := range oldNodeMap {
+    for oldId, node := range oldNodeMap {
         node.Id = ""
-        err := jsonStore.Add(node)
+        id, err := jsonStore.Add(node)
         if err != nil {
             fmt.Println("err:", err)
             // return err
             continue
         }
+        if state, ok := oldCardMap[oldId]; ok {
+            jsonStore.gaiaData.CardMap[id] = state
+        }
+        // a new id is no edit, Add would reset these.
+        added := jsonStore.gaiaData.NodeMap[id]
+        added.Updated, added.LastAccessed, added.AccessCount = node.Updated, node.LastAccessed, node.AccessCount
+        jsonStore.gaiaData.NodeMap[id] = added
     }
 
     return jsonStore.saveToFile()
@@ -323,46 +393,54 @@ func (jsonStore *JsonFileStore) load() error {
     if err != nil {
         if os.IsNotExist(err) {
             ioutil.WriteFile(jsonStore.FilePath, []byte(""), 0660)
-            err = nil
         } else {
             return err
         }
     }
 
-    if len(jsonStrBytes) > 0 {
-        err = json.Unmarshal(jsonStrBytes, jsonStore.gaiaData)
+    // old files are upgraded, see migrate.go
+    data, report, err := migrateData(jsonStrBytes, jsonStore.FilePath)
+    if err != nil {
+        return err
+    }
+    jsonStore.gaiaData = data
+    applyNodeUsage(data, readNodeUsage(jsonStore.usagePath()))
+    if len(report.Steps) > 0 {
+        backup, err := backupDataFile(jsonStore.FilePath, jsonStrBytes, report.From)
+        if err != nil {
+            return err
+        }
+        if err := jsonStore.saveToFile(); err != nil {
+            return err
+        }
+        fmt.Fprintf(os.Stderr, "migrated %s from version %d to %d, the old file is %s\n", jsonStore.FilePath, report.From, report.To, backup)
     }
 
     // write data backup file:
     ioutil.WriteFile(jsonStore.FilePath + ".bk", jsonStrBytes, 0660)
-
-    // init map if empty in gaiaData
-    if jsonStore.gaiaData.AliasMap == nil {
-        jsonStore.gaiaData.AliasMap = make(map[string]string)
-    }
-
-    if jsonStore.gaiaData.CategoryIdMap == nil {
-        jsonStore.gaiaData.CategoryIdMap = make(map[string]string)
-    }
-
-    if jsonStore.gaiaData.NameIdMap == nil {
-        jsonStore.gaiaData.NameIdMap = make(map[string]string)
-    }
-
-    if jsonStore.gaiaData.NodeMap == nil {
-        jsonStore.gaiaData.NodeMap = make(map[string]Node)
-    }
-
-    return err
+    return nil
 }
 
+// saveToFile does nothing without FilePath, GitStore keeps its own files.
 func (jsonStore *JsonFileStore) saveToFile() error {
+    if jsonStore.FilePath == "" {
+        return nil
+    }
+    jsonStore.gaiaData.Version = dataVersion
+    // usage goes to its own file, see NodeUsage.
     bs, err := json.MarshalIndent(jsonStore.gaiaData, "", "  ")
     if err != nil {
         return err
     }
 
-    return ioutil.WriteFile(jsonStore.FilePath, bs, 0660)
+    if err := ioutil.WriteFile(jsonStore.FilePath, bs, 0660); err != nil {
+        return err
+    }
+    return writeNodeUsage(jsonStore.usagePath(), jsonStore.gaiaData)
+}
+
+func (jsonStore *JsonFileStore) usagePath() string {
+    return filepath.Join(filepath.Dir(jsonStore.FilePath), usageFile)
 }
 
 func (jsonStore *JsonFileStore) generateId(nodeName string) (string, error) {
@@ -0,0 +1,238 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// meta lines with this prefix configure limits instead of the project, e.g.
+//   limit.timeout := 30s
+//   limit.memory := 512m
+const limitMetaPrefix = "limit."
+
+type ExecLimits struct {
+    Timeout time.Duration
+    Memory int64 // bytes
+    Cpu int      // cpu seconds
+    MaxOutput int64 // bytes
+    NoNetwork bool
+}
+
+func (limits ExecLimits) String() string {
+    res := []string{}
+    if limits.Timeout > 0 {
+        res = append(res, "timeout=" + limits.Timeout.String())
+    }
+    if limits.Memory > 0 {
+        res = append(res, "memory=" + strconv.FormatInt(limits.Memory, 10))
+    }
+    if limits.Cpu > 0 {
+        res = append(res, "cpu=" + strconv.Itoa(limits.Cpu) + "s")
+    }
+    if limits.MaxOutput > 0 {
+        res = append(res, "max-output=" + strconv.FormatInt(limits.MaxOutput, 10))
+    }
+    if limits.NoNetwork {
+        res = append(res, "no-network")
+    }
+    return strings.Join(res, " ")
+}
+
+// merge overrides limits with every field set in other.
+func (limits ExecLimits) merge(other ExecLimits) ExecLimits {
+    if other.Timeout > 0 {
+        limits.Timeout = other.Timeout
+    }
+    if other.Memory > 0 {
+        limits.Memory = other.Memory
+    }
+    if other.Cpu > 0 {
+        limits.Cpu = other.Cpu
+    }
+    if other.MaxOutput > 0 {
+        limits.MaxOutput = other.MaxOutput
+    }
+    if other.NoNetwork {
+        limits.NoNetwork = true
+    }
+    return limits
+}
+
+func (limits *ExecLimits) set(key, value string) error {
+    var err error
+    key = strings.ToLower(strings.TrimSpace(key))
+    value = strings.TrimSpace(value)
+    switch key {
+    case "timeout":
+        limits.Timeout, err = parseDuration(value)
+    case "memory":
+        limits.Memory, err = parseSize(value)
+    case "cpu":
+        var cpu time.Duration
+        cpu, err = parseDuration(value)
+        limits.Cpu = int(cpu / time.Second)
+    case "max-output", "maxoutput":
+        limits.MaxOutput, err = parseSize(value)
+    case "network":
+        limits.NoNetwork = value == "false" || value == "off" || value == "no"
+    case "no-network", "nonetwork":
+        limits.NoNetwork = value == "true" || value == "on" || value == "yes"
+    default:
+        err = errors.New("unknown limit: " + key)
+    }
+
+    if err != nil {
+        return newCodedError(ERR_INVALID, fmt.Sprintf("invalid limit %s=%s: %v", key, value, err))
+    }
+    return nil
+}
+
+func limitsFromConfig(config ExecConfig) (ExecLimits, error) {
+    limits := ExecLimits{NoNetwork: config.NoNetwork}
+    fields := map[string]string{
+        "timeout": config.Timeout,
+        "memory": config.Memory,
+        "cpu": config.Cpu,
+        "max-output": config.MaxOutput,
+    }
+    for k, v := range fields {
+        if strings.TrimSpace(v) == "" {
+            continue
+        }
+        if err := limits.set(k, v); err != nil {
+            return limits, err
+        }
+    }
+    return limits, nil
+}
+
+// parseMetaLimits takes limit.* lines out of meta content, the rest of
+// the meta is returned untouched for the project generators.
+func parseMetaLimits(meta string) (ExecLimits, string, error) {
+    limits := ExecLimits{}
+    restLines := []string{}
+    for _, line := range strings.Split(meta, "\n") {
+        lineTrimed := strings.TrimSpace(line)
+        if !strings.HasPrefix(lineTrimed, limitMetaPrefix) || strings.Index(lineTrimed, ":=") < 0 {
+            restLines = append(restLines, line)
+            continue
+        }
+
+        parts := strings.SplitN(lineTrimed, ":=", 2)
+        key := strings.TrimPrefix(strings.TrimSpace(parts[0]), limitMetaPrefix)
+        value := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+        if err := limits.set(key, value); err != nil {
+            return limits, meta, err
+        }
+    }
+    return limits, strings.Join(restLines, "\n"), nil
+}
+
+// parseDuration accepts go durations (30s, 2m) and plain seconds.
+func parseDuration(s string) (time.Duration, error) {
+    if n, err := strconv.Atoi(s); err == nil {
+        return time.Duration(n) * time.Second, nil
+    }
+    return time.ParseDuration(s)
+}
+
+// parseSize accepts plain bytes or a k/m/g suffix: 512m, 1g, 64k.
+func parseSize(s string) (int64, error) {
+    s = strings.ToLower(strings.TrimSpace(s))
+    s = strings.TrimSuffix(s, "b")
+    unit := int64(1)
+    switch {
+    case strings.HasSuffix(s, "k"):
+        unit = 1 << 10
+    case strings.HasSuffix(s, "m"):
+        unit = 1 << 20
+    case strings.HasSuffix(s, "g"):
+        unit = 1 << 30
+    }
+    if unit > 1 {
+        s = s[:len(s) - 1]
+    }
+
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil {
+        return 0, err
+    }
+    if n < 0 {
+        return 0, errors.New("negative size")
+    }
+    return n * unit, nil
+}
+
+// limitedWriter passes through at most max bytes, then calls onExceed once
+// and drops everything else.
+type limitedWriter struct {
+    w io.Writer
+    max int64
+    written int64
+    exceeded bool
+    onExceed func()
+    mu sync.Mutex
+}
+
+func newLimitedWriter(w io.Writer, max int64, onExceed func()) *limitedWriter {
+    return &limitedWriter{w: w, max: max, onExceed: onExceed}
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+    lw.mu.Lock()
+    defer lw.mu.Unlock()
+
+    if lw.exceeded {
+        return len(p), nil
+    }
+    if lw.max <= 0 || lw.written + int64(len(p)) <= lw.max {
+        lw.written += int64(len(p))
+        _, err := lw.w.Write(p)
+        return len(p), err
+    }
+
+    rest := lw.max - lw.written
+    lw.w.Write(p[:rest])
+    lw.written = lw.max
+    lw.exceeded = true
+    fmt.Fprintf(lw.w, "\n... output truncated at %d bytes\n", lw.max)
+    if lw.onExceed != nil {
+        lw.onExceed()
+    }
+    return len(p), nil
+}
+
+// rlimitWrap runs the command through sh so that ulimit applies only to it.
+// Without a cgroup for the memory limit it falls back to ulimit -v, which
+// caps the address space, not the memory in use.
+func rlimitWrap(parts []string, limits ExecLimits, memoryHandled bool) []string {
+    ulimits := []string{}
+    if limits.Memory > 0 && !memoryHandled {
+        ulimits = append(ulimits, "ulimit -v " + strconv.FormatInt(limits.Memory / 1024, 10))
+    }
+    if limits.Cpu > 0 {
+        ulimits = append(ulimits, "ulimit -t " + strconv.Itoa(limits.Cpu))
+    }
+    if len(ulimits) == 0 {
+        return parts
+    }
+
+    script := strings.Join(ulimits, " && ") + ` && exec "$@"`
+    return append([]string{"sh", "-c", script, "sh"}, parts...)
+}
+
+// reservesAddressSpace tells the runtimes that map far more than they use,
+// node and the jvm reserve gigabytes up front and go its heap arenas, so
+// an address space cap fails them long before the memory limit.
+func reservesAddressSpace(projectType ProjectType) bool {
+    switch projectType {
+    case NODEJS, TYPESCRIPT, JAVA, SCALA, GO:
+        return true
+    }
+    return false
+}
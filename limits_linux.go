@@ -0,0 +1,146 @@
+// +build linux
+
+package main
+
+import (
+    "errors"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// procLimiter applies the kernel side limits: a cgroup v2 memory cap when
+// our cgroup is delegated to us, and a network namespace for no-network.
+type procLimiter struct {
+    limits ExecLimits
+    cgroupDir string
+    cgroupFile *os.File
+}
+
+func newProcLimiter(limits ExecLimits) *procLimiter {
+    pl := &procLimiter{limits: limits}
+    if limits.Memory > 0 {
+        pl.setupCgroup()
+    }
+    return pl
+}
+
+func (pl *procLimiter) memoryHandled() bool {
+    return pl.cgroupFile != nil
+}
+
+func (pl *procLimiter) setupCgroup() {
+    selfDir := selfCgroupDir()
+    if selfDir == "" {
+        return
+    }
+
+    dir := filepath.Join(selfDir, "gaia-exec-" + strconv.Itoa(os.Getpid()))
+    if err := os.Mkdir(dir, 0755); err != nil {
+        return
+    }
+
+    memoryMax := []byte(strconv.FormatInt(pl.limits.Memory, 10))
+    if err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), memoryMax, 0644); err != nil {
+        os.Remove(dir)
+        return
+    }
+    // no swapping around the cap, ignore kernels without swap accounting.
+    ioutil.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+
+    f, err := os.Open(dir)
+    if err != nil {
+        os.Remove(dir)
+        return
+    }
+    pl.cgroupDir = dir
+    pl.cgroupFile = f
+}
+
+func selfCgroupDir() string {
+    if _, err := os.Stat(cgroupRoot + "/cgroup.controllers"); err != nil {
+        return ""
+    }
+
+    bs, err := ioutil.ReadFile("/proc/self/cgroup")
+    if err != nil {
+        return ""
+    }
+    for _, line := range strings.Split(string(bs), "\n") {
+        if strings.HasPrefix(line, "0::") {
+            return filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))
+        }
+    }
+    return ""
+}
+
+// apply sets up cmd, a non nil sandbox decides who owns the namespaces.
+func (pl *procLimiter) apply(cmd *exec.Cmd, sb *Sandbox) error {
+    attr := &syscall.SysProcAttr{Setpgid: true}
+
+    if pl.cgroupFile != nil {
+        attr.UseCgroupFD = true
+        attr.CgroupFD = int(pl.cgroupFile.Fd())
+    }
+
+    if sb != nil && sb.Mode == NAMESPACE_SANDBOX {
+        addSandboxNamespaces(attr)
+    }
+
+    // bwrap unshares the network by itself.
+    if pl.limits.NoNetwork && (sb == nil || sb.Mode != BWRAP_SANDBOX) {
+        if err := checkNamespaceSupport("net"); err != nil {
+            return err
+        }
+        attr.Cloneflags |= syscall.CLONE_NEWNET
+        if os.Getuid() != 0 && sb == nil {
+            addUserNamespace(attr)
+        }
+    }
+
+    cmd.SysProcAttr = attr
+    // kill the whole process group, npm and sbt leave children behind.
+    cmd.Cancel = func() error {
+        return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+    }
+    return nil
+}
+
+func (pl *procLimiter) close() {
+    if pl.cgroupFile != nil {
+        pl.cgroupFile.Close()
+        os.Remove(pl.cgroupDir)
+    }
+}
+
+func addUserNamespace(attr *syscall.SysProcAttr) {
+    attr.Cloneflags |= syscall.CLONE_NEWUSER
+    attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+    attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+    attr.GidMappingsEnableSetgroups = false
+}
+
+func checkNamespaceSupport(ns string) error {
+    if _, err := os.Stat("/proc/self/ns/" + ns); err != nil {
+        return errors.New("kernel does not support " + ns + " namespaces")
+    }
+    if os.Getuid() == 0 {
+        return nil
+    }
+
+    bs, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces")
+    if err == nil && strings.TrimSpace(string(bs)) == "0" {
+        return errors.New("user namespaces are disabled on this host (user.max_user_namespaces=0)")
+    }
+    bs, err = ioutil.ReadFile("/proc/sys/kernel/unprivileged_userns_clone")
+    if err == nil && strings.TrimSpace(string(bs)) == "0" {
+        return errors.New("unprivileged user namespaces are disabled on this host (kernel.unprivileged_userns_clone=0)")
+    }
+    return nil
+}
@@ -0,0 +1,31 @@
+// +build !linux
+
+package main
+
+import (
+    "errors"
+    "os/exec"
+)
+
+// procLimiter only knows rlimits outside linux, see limits_linux.go.
+type procLimiter struct {
+    limits ExecLimits
+}
+
+func newProcLimiter(limits ExecLimits) *procLimiter {
+    return &procLimiter{limits: limits}
+}
+
+func (pl *procLimiter) memoryHandled() bool {
+    return false
+}
+
+func (pl *procLimiter) apply(cmd *exec.Cmd, sb *Sandbox) error {
+    if pl.limits.NoNetwork && (sb == nil || sb.Mode != BWRAP_SANDBOX) {
+        return errors.New("no-network mode needs linux network namespaces")
+    }
+    return nil
+}
+
+func (pl *procLimiter) close() {
+}
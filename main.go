@@ -1,6 +1,7 @@
 package main
 
 import (
+    "errors"
     "fmt"
     "flag"
     "os"
@@ -9,14 +10,8 @@ import (
     "io/ioutil"
 )
 
-// TODO: execute code from system clipboard
-// TODO: copy code to clipboard
-
 // TODO: read all items by skip:count
-// TODO: generate all as a pdf file.
 
-// CARD NOTE
-// card note chain:  exchange card.
 // Link between note.
 
 var gaiaDir = ".gaia/"
@@ -26,7 +21,9 @@ var codeBase = "codebase/"
 
 var subCommands = []string{
     "add",
+    "new",
     "get",
+    "toc",
     "alias",
     "append",
     "merge",
@@ -35,22 +32,42 @@ var subCommands = []string{
     "remove",
     "edit",
     "exec",
+    "import",
+    "export",
+    "export-project",
+    "import-project",
+    "vars",
     "stats",
+    "review",
+    "bundle",
+    "sync",
+    "serve",
     "admin",
 }
 
 var subCommandMap = map[string]string{
     "add": "add item",
-    "get": "get item by id",
+    "new": "add item written in $EDITOR",
+    "get": "get item by id, id#anchor or id:from-to",
+    "toc": "list the anchors of an item",
     "alias": "add keyword alias",
     "append": "append text to item content",
     "merge": "merge two item into one",
     "list": "list items",
     "search": "search items",
     "remove": "remove item by id",
-    "edit": "edit item in vi",
+    "edit": "edit item in $EDITOR",
     "exec": "execute item",
+    "import": "import a directory of notes and code files",
+    "export": "export items as markdown files, a static site, a pdf or an html book",
+    "export-project": "write a multi-file item out as files",
+    "import-project": "pack a directory into one item",
+    "vars": "list template variables of item",
     "stats": "stats info",
+    "review": "review items tagged card, spaced repetition",
+    "bundle": "create, import or sign a bundle of items to share",
+    "sync": "pull, merge and push the git store",
+    "serve": "serve items over a http json api and a web ui",
     "admin": "admin",
 }
 
@@ -72,11 +89,50 @@ var (
     listTags bool
     listAlias bool
     listNames bool
+    listRecent bool
+    listStale string
+    searchSort string
     countStats bool
     onlyContent bool
     isFormat bool
     isRemove bool
     isReorg bool
+    isRepair bool
+    isCheck bool
+
+    execTimeout string
+    execMemory string
+    execCpu string
+    execMaxOutput string
+    execNoNetwork bool
+    execSandbox bool
+    execClean bool
+
+    templateValues = varsFlag{}
+    outputFormat string
+    skipConfirm bool
+    fromClipboard bool
+    isSecret bool
+    unlockSecrets bool
+    copyContent bool
+    execClipboard bool
+
+    importOptions ImportOptions
+    exportOptions ExportOptions
+
+    reviewLimit int
+    reviewStats bool
+
+    bundleOptions BundleOptions
+    bundleIds string
+
+    syncInit bool
+
+    serveAddr string
+    serveToken string
+    serveCors string
+    serveReadOnly bool
+    serveUi bool
 )
 
 func init() {
@@ -87,7 +143,11 @@ func init() {
 
     gaiaDir = usr.HomeDir + "/" + gaiaDir
     dataFilePath = gaiaDir + dataFileName
+    configFilePath = gaiaDir + configFileName
+    execCacheDir = gaiaDir + execCacheDir
     codeBase = gaiaDir + codeBase
+    attachmentsDir = gaiaDir + attachmentsDir
+    bundleKeyFile = gaiaDir + bundleKeyFile
     _, err = os.Stat(gaiaDir)
     if err != nil && os.IsNotExist(err) {
         err = os.MkdirAll(gaiaDir, 0770)
@@ -98,7 +158,17 @@ func init() {
 }
 
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == sandboxInitCommand {
+        sandboxInit(os.Args[2:])
+        return
+    }
+
     flag.BoolVar(&isHelp, "h", false, "show help message")
+    flag.StringVar(&outputFormat, "output", TEXT_OUTPUT, "output format: text, json or yaml")
+    os.Args, outputFormat = extractOutputFlag(os.Args)
+    if outputFormat == "" {
+        outputFormat = TEXT_OUTPUT
+    }
     if len(os.Args) == 1 {
         printUsage()
         os.Exit(-1)
@@ -126,15 +196,30 @@ func main() {
         subFlag.StringVar(&content, "b", "", "node body content")
         subFlag.BoolVar(&executable, "e", false, "is node executable")
         subFlag.StringVar(&mainFile, "m", "", "executable main file name")
-        subFlag.StringVar(&inputFile, "f", "", "node body content input file")
+        subFlag.StringVar(&inputFile, "f", "", "node body content input file, - for stdin")
+        subFlag.BoolVar(&fromClipboard, "from-clipboard", false, "read body from the clipboard")
+        subFlag.BoolVar(&isSecret, "secret", false, "encrypt the body with a passphrase")
 
         subFlag.Usage = func() {
             fmt.Printf("Usage: %s %s -n name -c category -b body [<other args>] \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s -n name [<other args>] -    read body from stdin \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "new":
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s [name] \n", os.Args[0], os.Args[1])
             subFlag.PrintDefaults()
         }
     case "get":
         subFlag.StringVar(&id, "i", "", "node id")
         subFlag.BoolVar(&onlyContent, "c", false, "only print content")
+        subFlag.BoolVar(&copyContent, "copy", false, "copy content, or the #anchor section, to the clipboard")
+        subFlag.Var(templateValues, "set", "set template variable, name=value, repeatable")
+    case "toc":
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s <id> \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
     case "alias":
         subFlag.BoolVar(&isRemove, "r", false, "remove alias")
         subFlag.Usage = func() {
@@ -153,19 +238,115 @@ func main() {
         subFlag.BoolVar(&listTags, "t", false, "list node tags")
         subFlag.BoolVar(&listNames, "n", false, "list by name parts")
         subFlag.BoolVar(&listAlias, "a", false, "list global keyword alias")
+        subFlag.BoolVar(&listRecent, "recent", false, "list by the time added or updated, newest first")
+        subFlag.StringVar(&listStale, "stale", "", "list nodes not used for an age, e.g. 180d")
     case "search":
         subFlag.StringVar(&category, "c", "", "search in certain category")
+        subFlag.BoolVar(&unlockSecrets, "unlock", false, "include secret items")
+        subFlag.StringVar(&searchSort, "sort", "", "sort by usage or recent")
     case "remove":
         subFlag.StringVar(&id, "i", "", "node id")
+        subFlag.BoolVar(&skipConfirm, "y", false, "remove without asking")
     case "edit":
         subFlag.StringVar(&id, "i", "", "node id")
     case "exec":
         subFlag.StringVar(&id, "i", "", "node id")
+        subFlag.StringVar(&execTimeout, "timeout", "", "kill the run after this duration, e.g. 30s")
+        subFlag.StringVar(&execMemory, "memory", "", "memory limit, e.g. 512m, an address space cap where cgroup v2 is not delegated")
+        subFlag.StringVar(&execCpu, "cpu", "", "cpu time limit, e.g. 60s")
+        subFlag.StringVar(&execMaxOutput, "max-output", "", "max output size, e.g. 1m")
+        subFlag.BoolVar(&execNoNetwork, "no-network", false, "run without network access")
+        subFlag.BoolVar(&execSandbox, "sandbox", false, "run in a sandbox, only the project dir is writable")
+        subFlag.BoolVar(&execClean, "clean", false, "rebuild the cached project from scratch")
+        subFlag.Var(templateValues, "set", "set template variable, name=value, repeatable")
+        subFlag.BoolVar(&execClipboard, "clipboard", false, "run the clipboard, the main file name picks the language")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s [<args>] <file|id> \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s --clipboard [<args>] <main file name, e.g. main.go> \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "import":
+        subFlag.StringVar(&importOptions.Category, "category", "", "category put in front of every name")
+        subFlag.StringVar(&importOptions.NameFrom, "name-from", NAME_FROM_PATH, "derive names from path, frontmatter or heading")
+        subFlag.StringVar(&importOptions.OnConflict, "on-conflict", CONFLICT_SKIP, "existing names: skip, rename or overwrite")
+        subFlag.BoolVar(&importOptions.DryRun, "dry-run", false, "only report what would be imported")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s <dir> [<args>] \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "export":
+        subFlag.StringVar(&exportOptions.Category, "category", "", "only export this category")
+        subFlag.StringVar(&exportOptions.Tag, "tag", "", "only export nodes with this tag")
+        subFlag.BoolVar(&exportOptions.Unlock, "unlock", false, "include secret nodes, decrypted")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s md <dir> | site <dir> | pdf <file> | html <file> [<args>] \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "export-project":
+        subFlag.StringVar(&id, "i", "", "node id")
+        subFlag.BoolVar(&unlockSecrets, "unlock", false, "export a secret node, decrypted")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s <id> <dir> \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "import-project":
+        subFlag.StringVar(&name, "n", "", "node name")
+        subFlag.StringVar(&tags, "t", "", "node tags, tag seprated by comma")
+        subFlag.StringVar(&desc, "d", "", "node description")
+        subFlag.BoolVar(&executable, "e", false, "is node executable")
+        subFlag.StringVar(&mainFile, "m", "", "main file, relative to dir")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s <dir> -n name [<other args>] \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "vars":
+        subFlag.StringVar(&id, "i", "", "node id")
     case "stats":
         subFlag.BoolVar(&countStats, "n", false, "count stats")
+    case "review":
+        subFlag.StringVar(&tags, "tag", "", "only review cards with this tag too")
+        subFlag.IntVar(&reviewLimit, "limit", 0, "review at most this many cards")
+        subFlag.BoolVar(&reviewStats, "stats", false, "show due counts and retention instead")
+    case "bundle":
+        subFlag.StringVar(&bundleIds, "ids", "", "node ids to bundle, seprated by comma")
+        subFlag.StringVar(&bundleOptions.Tag, "tag", "", "bundle the nodes with this tag")
+        subFlag.BoolVar(&bundleOptions.Unlock, "unlock", false, "include secret nodes, decrypted")
+        subFlag.StringVar(&bundleOptions.Output, "o", "", "bundle file to create, e.g. notes.gaia")
+        subFlag.BoolVar(&bundleOptions.Sign, "sign", false, "sign the bundle with the key of bundle keygen")
+        subFlag.BoolVar(&bundleOptions.Verify, "verify", false, "only import bundles signed by a trusted key")
+        subFlag.StringVar(&bundleOptions.OnConflict, "on-conflict", CONFLICT_RENAME, "existing names: skip, rename or overwrite")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s create --ids id1,id2 | --tag tag -o file.gaia [--sign] [--unlock] \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s import file.gaia [--verify] [--on-conflict rename] \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s keygen \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "sync":
+        subFlag.BoolVar(&syncInit, "init", false, "create the git store of config Git.Dir, cloning url or importing the current items")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s --init [<url>] \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
+    case "serve":
+        subFlag.StringVar(&serveAddr, "addr", "", "listen address, default " + defaultServeAddr)
+        subFlag.StringVar(&serveToken, "token", "", "require this bearer token, also read from $" + serveTokenEnv)
+        subFlag.StringVar(&serveCors, "cors", "", "allowed cors origins, seprated by comma, * for any")
+        subFlag.BoolVar(&serveReadOnly, "read-only", false, "reject requests that change items")
+        subFlag.BoolVar(&serveUi, "ui", false, "also serve the web ui on /")
     case "admin":
         subFlag.BoolVar(&isFormat, "f", false, "format all data")
         subFlag.BoolVar(&isReorg, "ro", false, "reorg all data")
+        subFlag.BoolVar(&isRepair, "repair", false, "fsck: fix what can be fixed")
+        subFlag.BoolVar(&isCheck, "check", false, "migrate: only show what would change")
+        subFlag.Usage = func() {
+            fmt.Printf("Usage: %s %s [<args>] \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s gc-exec    prune cached exec projects \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s fsck [--repair]    check the data for broken maps, links and aliases \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s migrate [--check]    upgrade data.json to the current version \n", os.Args[0], os.Args[1])
+            fmt.Printf("       %s %s merge-data <base> <ours> <theirs>    merge data.json copies into ours, a git merge driver \n", os.Args[0], os.Args[1])
+            subFlag.PrintDefaults()
+        }
     default:
         fmt.Println("Unrecogniz command:", os.Args[1])
         printUsage()
@@ -173,20 +354,55 @@ func main() {
     }
 
     subFlag.BoolVar(&isHelp, "h", false, "show help message")
+    subFlag.StringVar(&outputFormat, "output", outputFormat, "output format: text, json or yaml")
 
-    if len(os.Args) == 2 ||
-        os.Args[2] == "-h" ||
-        os.Args[2] == "--help" {
+    if err := checkOutputFormat(outputFormat); err != nil {
+        outputFormat = TEXT_OUTPUT
+        exitWithError(err)
+    }
+
+    if len(os.Args) == 2 && !ArrContains([]string{"new", "serve", "review", "sync"}, os.Args[1]) ||
+        len(os.Args) > 2 && os.Args[2] == "-h" ||
+        len(os.Args) > 2 && os.Args[2] == "--help" {
         subFlag.Usage()
         os.Exit(2)
     }
 
-    subFlag.Parse(os.Args[2:])
+    switch os.Args[1] {
+    case "add", "get", "exec", "import", "export", "export-project", "import-project", "bundle", "admin":
+        parseInterspersed(subFlag, os.Args[2:])
+    default:
+        subFlag.Parse(os.Args[2:])
+    }
     processSubCommand(os.Args[1])
 }
 
 func processSubCommand(command string) {
-    op := newOperator(newJsonFileStore(dataFilePath))
+    config, err := loadConfig(configFilePath)
+    if err != nil {
+        exitWithError(errors.New("can not read config: " + err.Error()))
+    }
+    var store Store
+    if config.Git.Dir != "" && !(command == "sync" && syncInit) {
+        gitStore, err := newGitStore(config.Git)
+        if err != nil {
+            exitWithError(err)
+        }
+        store = gitStore
+    } else if !(command == "admin" && subFlag.Arg(0) == "migrate") {
+        // migrate reads the file itself, loading would upgrade it.
+        jsonStore, err := newJsonFileStore(dataFilePath)
+        if err != nil {
+            exitWithError(err)
+        }
+        store = jsonStore
+    }
+    op := newOperator(store)
+    op.format = outputFormat
+    op.editor = resolveEditor(config.Editor)
+    op.clipboardName = config.Clipboard
+    op.passphraseFile = config.Secret.PassphraseFile
+    op.author = config.Author
     // fmt.Println("dataFilePath:", dataFilePath)
 
     switch command {
@@ -195,18 +411,34 @@ func processSubCommand(command string) {
         if executable {
             checkRequiredArg("-m", mainFile)
         }
+        if content == "" && fromClipboard {
+            var err error
+            if content, err = op.PasteClipboard(); err != nil {
+                exitWithError(err)
+            }
+        }
         if content == "" {
+            if inputFile == "" && len(subFlag.Args()) > 0 && subFlag.Args()[0] == "-" {
+                inputFile = "-"
+            }
             if inputFile == "" {
-                fmt.Println("-b and -f can not both be empty")
-                os.Exit(2)
+                exitWithError(newCodedError(ERR_USAGE, "-b, -f and --from-clipboard can not all be empty"))
             }
 
-            contentBs, _ := ioutil.ReadFile(inputFile)
+            var contentBs []byte
+            var err error
+            if inputFile == "-" {
+                contentBs, err = ioutil.ReadAll(os.Stdin)
+            } else {
+                contentBs, err = ioutil.ReadFile(inputFile)
+            }
+            if err != nil {
+                exitWithError(err)
+            }
             content = string(contentBs)
         }
         if strings.TrimSpace(content) == "" {
-            fmt.Println("node content is empty!")
-            os.Exit(2)
+            exitWithError(newCodedError(ERR_INVALID, "node content is empty!"))
         }
 
         node := Node{
@@ -217,13 +449,22 @@ func processSubCommand(command string) {
             Content: content,
             Executable: executable,
             ExecFile: mainFile,
+            Secret: isSecret,
         }
         op.Add(node)
+    case "new":
+        op.New(strings.Join(subFlag.Args(), "-"))
     case "get":
         if id == "" && len(subFlag.Args()) > 0 {
             id = subFlag.Args()[0]
         }
-        op.Get(id, onlyContent)
+        op.Get(id, onlyContent, copyContent, templateValues)
+    case "toc":
+        if len(subFlag.Args()) != 1 {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        op.Toc(subFlag.Args()[0])
     case "alias":
         aliasArgs := subFlag.Args()
 
@@ -253,6 +494,10 @@ func processSubCommand(command string) {
             op.ListCates()
         } else if listTags {
             op.ListTags()
+        } else if listRecent {
+            op.ListRecent(subFlag.Args())
+        } else if listStale != "" {
+            op.ListStale(listStale, subFlag.Args())
         } else if listNames {
             op.ListNodes(subFlag.Args())
         } else {
@@ -260,7 +505,7 @@ func processSubCommand(command string) {
             os.Exit(2)
         }
     case "search":
-        op.Search(category, subFlag.Args())
+        op.Search(category, subFlag.Args(), unlockSecrets, searchSort)
     case "remove":
         if id == "" && len(subFlag.Args()) > 0 {
             id = subFlag.Args()[0]
@@ -271,6 +516,15 @@ func processSubCommand(command string) {
             os.Exit(2)
         }
 
+        if skipConfirm {
+            op.Remove(id)
+            break
+        }
+        // the prompt would end up in the json or yaml output.
+        if outputFormat != TEXT_OUTPUT {
+            exitWithError(newCodedError(ERR_USAGE, "remove needs -y with --output " + outputFormat))
+        }
+
         fmt.Println("Are you sure to remove node with id "+ id +"?", "  yes|no")
         var response string
         _, err := fmt.Scanln(&response)
@@ -290,11 +544,140 @@ func processSubCommand(command string) {
 
         op.Edit(id)
     case "exec":
-        file := os.Args[2]
-        op.Exec(file)
+        if id == "" && len(subFlag.Args()) > 0 {
+            id = subFlag.Args()[0]
+        }
+        if id == "" {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        options, err := execOptions()
+        if err != nil {
+            exitWithError(err)
+        }
+        if execClipboard {
+            op.ExecClipboard(id, options, templateValues)
+        } else {
+            op.Exec(id, options, templateValues)
+        }
+    case "import":
+        if len(subFlag.Args()) != 1 {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        if err := checkImportOptions(importOptions); err != nil {
+            exitWithError(err)
+        }
+        op.Import(subFlag.Args()[0], importOptions)
+    case "export":
+        if len(subFlag.Args()) != 2 {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        op.Export(subFlag.Args()[0], subFlag.Args()[1], exportOptions)
+    case "export-project":
+        args := subFlag.Args()
+        if id == "" && len(args) > 1 {
+            id = args[0]
+            args = args[1:]
+        }
+        if id == "" || len(args) != 1 {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        op.ExportProject(id, args[0], unlockSecrets)
+    case "import-project":
+        checkRequiredArg("-n", name)
+        if len(subFlag.Args()) != 1 {
+            subFlag.Usage()
+            os.Exit(2)
+        }
+        node := Node{
+            Name: name,
+            Tags: tags,
+            Desc: desc,
+            Executable: executable,
+            ExecFile: mainFile,
+        }
+        op.ImportProject(subFlag.Args()[0], node)
+    case "vars":
+        if id == "" && len(subFlag.Args()) > 0 {
+            id = subFlag.Args()[0]
+        }
+        op.Vars(id)
     case "stats":
         op.Stats()
+    case "review":
+        if reviewStats {
+            op.ReviewStats(tags)
+        } else {
+            op.Review(tags, reviewLimit, os.Stdin, os.Stdout)
+        }
+    case "bundle":
+        args := subFlag.Args()
+        bundleOptions.Author = config.Bundle.Author
+        if bundleOptions.Author == "" {
+            if usr, err := user.Current(); err == nil {
+                bundleOptions.Author = usr.Username
+            }
+        }
+        bundleOptions.KeyFile = bundleKeyFile
+        if config.Bundle.KeyFile != "" {
+            bundleOptions.KeyFile = config.Bundle.KeyFile
+        }
+        bundleOptions.TrustedKeys = config.Bundle.TrustedKeys
+        bundleOptions.Ids = splitList(bundleIds)
+        switch {
+        case len(args) == 1 && args[0] == "create":
+            if len(bundleOptions.Ids) == 0 && bundleOptions.Tag == "" {
+                exitWithError(newCodedError(ERR_USAGE, "--ids or --tag is required"))
+            }
+            checkRequiredArg("-o", bundleOptions.Output)
+            op.BundleCreate(bundleOptions)
+        case len(args) == 2 && args[0] == "import":
+            if !ArrContains([]string{CONFLICT_SKIP, CONFLICT_RENAME, CONFLICT_OVERWRITE}, bundleOptions.OnConflict) {
+                exitWithError(newCodedError(ERR_USAGE, "--on-conflict must be skip, rename or overwrite"))
+            }
+            op.BundleImport(args[1], bundleOptions)
+        case len(args) == 1 && args[0] == "keygen":
+            op.BundleKeygen(bundleOptions.KeyFile)
+        default:
+            subFlag.Usage()
+            os.Exit(2)
+        }
+    case "sync":
+        if syncInit {
+            url := ""
+            if len(subFlag.Args()) > 0 {
+                url = subFlag.Args()[0]
+            }
+            op.SyncInit(config.Git, url)
+        } else {
+            op.Sync()
+        }
+    case "serve":
+        op.Serve(serveOptions(config.Serve))
     case "admin":
+        if len(subFlag.Args()) > 0 && subFlag.Args()[0] == "gc-exec" {
+            options, err := execOptions()
+            if err != nil {
+                exitWithError(err)
+            }
+            op.GcExecCache(options.CacheSize)
+        }
+        if len(subFlag.Args()) > 0 && subFlag.Args()[0] == "fsck" {
+            op.Fsck(isRepair)
+        }
+        if len(subFlag.Args()) > 0 && subFlag.Args()[0] == "migrate" {
+            op.MigrateData(dataFilePath, isCheck)
+        }
+        if len(subFlag.Args()) > 0 && subFlag.Args()[0] == "merge-data" {
+            if len(subFlag.Args()) != 4 {
+                subFlag.Usage()
+                os.Exit(2)
+            }
+            op.MergeData(subFlag.Args()[1], subFlag.Args()[2], subFlag.Args()[3])
+        }
         if isFormat {
             op.FormatData()
         }
@@ -306,15 +689,106 @@ func processSubCommand(command string) {
         os.Exit(2)
     }
 
-    if op.err != nil {
+    if !op.isText() {
+        printResult(op.format, command, op.data, op.err)
+        if op.err != nil {
+            os.Exit(1)
+        }
+    } else if op.err != nil {
         fmt.Println("error:", op.err)
+        // git runs merge-data as a merge driver, a conflict has to fail,
+        // and a failed exec fails like the run.
+        if command == "admin" || command == "exec" {
+            os.Exit(1)
+        }
     }
 }
 
+// exitWithError reports an error found before the operator ran.
+func exitWithError(err error) {
+    if outputFormat == TEXT_OUTPUT {
+        fmt.Println(err)
+    } else {
+        printResult(outputFormat, os.Args[1], nil, err)
+    }
+    os.Exit(2)
+}
+
+// execOptions reads limits from config and from the command line, the meta
+// block of the executed file sits between the two.
+func execOptions() (ExecOptions, error) {
+    options := ExecOptions{Sandbox: execSandbox, Clean: execClean, CacheSize: defaultExecCacheSize}
+    config, err := loadConfig(configFilePath)
+    if err != nil {
+        return options, errors.New("can not read config: " + err.Error())
+    }
+    options.SandboxPaths = config.Sandbox.ReadOnlyPaths
+    if config.Exec.CacheSize != "" {
+        options.CacheSize, err = parseSize(config.Exec.CacheSize)
+        if err != nil {
+            return options, errors.New("invalid exec cache size: " + err.Error())
+        }
+    }
+    options.Limits, err = limitsFromConfig(config.Exec)
+    if err != nil {
+        return options, err
+    }
+
+    options.FlagLimits, err = limitsFromConfig(ExecConfig{
+        Timeout: execTimeout,
+        Memory: execMemory,
+        Cpu: execCpu,
+        MaxOutput: execMaxOutput,
+        NoNetwork: execNoNetwork,
+    })
+    return options, err
+}
+
+// serveOptions lays the serve flags over the config, the token may also
+// come from the environment to keep it out of the process list.
+func serveOptions(config ServeConfig) ServeOptions {
+    options := ServeOptions{Addr: config.Addr, Token: config.Token, CorsOrigins: config.CorsOrigins, ReadOnly: config.ReadOnly || serveReadOnly, UI: serveUi}
+    if serveAddr != "" {
+        options.Addr = serveAddr
+    }
+    if options.Addr == "" {
+        options.Addr = defaultServeAddr
+    }
+    if token := os.Getenv(serveTokenEnv); token != "" {
+        options.Token = token
+    }
+    if serveToken != "" {
+        options.Token = serveToken
+    }
+    if serveCors != "" {
+        options.CorsOrigins = splitList(serveCors)
+    }
+    return options
+}
+
+// parseInterspersed parses flags given after positional args too, the
+// positional args end up in fs.Args().
+func parseInterspersed(fs *flag.FlagSet, args []string) {
+    positional := []string{}
+    for {
+        fs.Parse(args)
+        args = fs.Args()
+        if len(args) == 0 {
+            break
+        }
+        if args[0] == "--" {
+            positional = append(positional, args[1:]...)
+            break
+        }
+        positional = append(positional, args[0])
+        args = args[1:]
+    }
+    fs.Parse(append([]string{"--"}, positional...))
+}
+
 func checkRequiredArg(argName, argValue string) {
     if strings.TrimSpace(argValue) == "" {
-        fmt.Println("Missing required arg: ", argName)
-        os.Exit(2)
+        exitWithError(newCodedError(ERR_USAGE, "Missing required arg: " + argName))
     }
 }
 
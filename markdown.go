@@ -0,0 +1,320 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const frontMatterDelimiter = "---"
+
+// front matter keys in the order they are written.
+var frontMatterKeys = []string{"id", "name", "tags", "desc", "executable", "exec_file", "links", "attachments", "secret", "author", "created", "updated"}
+
+type FrontMatterError struct {
+    Line int
+    Msg string
+}
+
+func (e *FrontMatterError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Markdown renders the node as yaml front matter followed by the content
+// verbatim, ParseMarkdown reads it back.
+//
+//   ---
+//   id: "0000"
+//   name: os-ssh-connect
+//   tags: [ssh, remote]
+//   ...
+//   ---
+//   ssh {{user:root}}@{{host}}
+func (node Node) Markdown() string {
+    res := frontMatterDelimiter + "\n"
+    if node.Id != "" {
+        res += "id: " + yamlString(node.Id) + "\n"
+    }
+    res += "name: " + yamlString(node.Name) + "\n"
+    res += "tags: " + yamlFlowList(splitList(node.Tags)) + "\n"
+    res += "desc: " + yamlString(node.Desc) + "\n"
+    res += "executable: " + strconv.FormatBool(node.Executable) + "\n"
+    res += "exec_file: " + yamlString(node.ExecFile) + "\n"
+    res += "links: " + yamlFlowList(splitList(node.Links)) + "\n"
+    if len(node.Attachments) > 0 {
+        res += "attachments: " + yamlFlowList(node.Attachments) + "\n"
+    }
+    if node.Secret {
+        res += "secret: true\n"
+    }
+    if node.Author != "" {
+        res += "author: " + yamlString(node.Author) + "\n"
+    }
+    if !node.Created.IsZero() {
+        res += "created: " + yamlString(node.Created.UTC().Format(time.RFC3339)) + "\n"
+    }
+    if !node.Updated.IsZero() {
+        res += "updated: " + yamlString(node.Updated.UTC().Format(time.RFC3339)) + "\n"
+    }
+    res += frontMatterDelimiter + "\n"
+    res += node.Content + "\n"
+    return res
+}
+
+// ParseMarkdown overwrites the fields present in the front matter, the body
+// becomes Content as is, minus the newline Markdown puts after it.
+func (node *Node) ParseMarkdown(text string) error {
+    lines := strings.SplitAfter(text, "\n")
+    if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterDelimiter {
+        return &FrontMatterError{1, "expect front matter starting with " + frontMatterDelimiter}
+    }
+
+    end := -1
+    for i := 1; i < len(lines); i++ {
+        if strings.TrimRight(lines[i], " \r\n") == frontMatterDelimiter {
+            end = i
+            break
+        }
+    }
+    if end < 0 {
+        return &FrontMatterError{len(lines), "front matter is not closed by " + frontMatterDelimiter}
+    }
+
+    values, err := parseFrontMatter(lines[1:end], true)
+    if err != nil {
+        return err
+    }
+
+    parsed := *node
+    for key, v := range values {
+        switch key {
+        case "id":
+            parsed.Id = v.scalar
+        case "name":
+            parsed.Name = v.scalar
+        case "tags":
+            parsed.Tags = strings.Join(v.list, ",")
+        case "desc":
+            parsed.Desc = v.scalar
+        case "executable":
+            b, err := strconv.ParseBool(v.scalar)
+            if err != nil {
+                return &FrontMatterError{v.line, "executable must be true or false"}
+            }
+            parsed.Executable = b
+        case "exec_file":
+            parsed.ExecFile = v.scalar
+        case "links":
+            parsed.Links = strings.Join(v.list, ",")
+        case "attachments":
+            parsed.Attachments = v.list
+        case "secret":
+            b, err := strconv.ParseBool(v.scalar)
+            if err != nil {
+                return &FrontMatterError{v.line, "secret must be true or false"}
+            }
+            parsed.Secret = b
+        case "author":
+            parsed.Author = v.scalar
+        case "created", "updated":
+            t, err := time.Parse(time.RFC3339, v.scalar)
+            if err != nil {
+                return &FrontMatterError{v.line, key + " must be a time like 2006-01-02T15:04:05Z"}
+            }
+            if key == "created" {
+                parsed.Created = t
+            } else {
+                parsed.Updated = t
+            }
+        }
+    }
+    if strings.TrimSpace(parsed.Name) == "" {
+        return &FrontMatterError{2, "name is empty"}
+    }
+
+    body := strings.Join(lines[end + 1:], "")
+    parsed.Content = strings.TrimSuffix(body, "\n")
+    *node = parsed
+    return nil
+}
+
+type frontMatterValue struct {
+    line int
+    scalar string
+    list []string
+}
+
+// parseFrontMatter knows the small yaml subset Markdown writes: scalars,
+// flow lists [a, b] and block lists of "- a" lines. # starts a comment line.
+// Unknown keys are errors when strict, else they are skipped.
+func parseFrontMatter(lines []string, strict bool) (map[string]*frontMatterValue, error) {
+    values := make(map[string]*frontMatterValue)
+    var listValue *frontMatterValue
+    skipping := false
+    for i, rawLine := range lines {
+        lineNo := i + 2
+        line := strings.TrimRight(rawLine, " \r\n")
+        lineTrimed := strings.TrimSpace(line)
+        if lineTrimed == "" || strings.HasPrefix(lineTrimed, "#") {
+            continue
+        }
+
+        if skipping && (line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(lineTrimed, "- ")) {
+            continue
+        }
+        skipping = false
+
+        if strings.HasPrefix(lineTrimed, "- ") || lineTrimed == "-" {
+            if listValue == nil {
+                return nil, &FrontMatterError{lineNo, "list item without a key"}
+            }
+            item, err := parseYamlScalar(strings.TrimSpace(strings.TrimPrefix(lineTrimed, "-")))
+            if err != nil {
+                return nil, &FrontMatterError{lineNo, err.Error()}
+            }
+            listValue.list = append(listValue.list, item)
+            continue
+        }
+        listValue = nil
+
+        index := strings.Index(line, ":")
+        if index <= 0 || line[0] == ' ' {
+            return nil, &FrontMatterError{lineNo, "expect key: value"}
+        }
+        key := strings.TrimSpace(line[:index])
+        if !ArrContains(frontMatterKeys, key) {
+            if !strict {
+                skipping = true
+                continue
+            }
+            return nil, &FrontMatterError{lineNo, "unknown key: " + key}
+        }
+        if _, exist := values[key]; exist {
+            return nil, &FrontMatterError{lineNo, "duplicated key: " + key}
+        }
+
+        rawValue := strings.TrimSpace(line[index + 1:])
+        v := &frontMatterValue{line: lineNo}
+        values[key] = v
+        if rawValue == "" {
+            listValue = v
+            continue
+        }
+
+        var err error
+        if strings.HasPrefix(rawValue, "[") {
+            v.list, err = parseYamlFlowList(rawValue)
+            v.scalar = strings.Join(v.list, ",")
+        } else {
+            v.scalar, err = parseYamlScalar(rawValue)
+            v.list = splitList(v.scalar)
+        }
+        if err != nil {
+            return nil, &FrontMatterError{lineNo, err.Error()}
+        }
+    }
+    return values, nil
+}
+
+func parseYamlScalar(s string) (string, error) {
+    if strings.HasPrefix(s, "\"") {
+        var res string
+        if err := json.Unmarshal([]byte(s), &res); err != nil {
+            return "", fmt.Errorf("bad double quoted string: %s", s)
+        }
+        return res, nil
+    }
+    if strings.HasPrefix(s, "'") {
+        if len(s) < 2 || !strings.HasSuffix(s, "'") {
+            return "", fmt.Errorf("bad single quoted string: %s", s)
+        }
+        return strings.Replace(s[1:len(s) - 1], "''", "'", -1), nil
+    }
+    if s == "~" || s == "null" {
+        return "", nil
+    }
+    return s, nil
+}
+
+func parseYamlFlowList(s string) ([]string, error) {
+    if !strings.HasSuffix(s, "]") {
+        return nil, fmt.Errorf("list is not closed by ]: %s", s)
+    }
+    inner := strings.TrimSpace(s[1:len(s) - 1])
+    res := []string{}
+    if inner == "" {
+        return res, nil
+    }
+
+    // split on commas outside of quotes.
+    item := ""
+    var quote rune
+    items := []string{}
+    for _, r := range inner {
+        switch {
+        case quote != 0:
+            if r == quote {
+                quote = 0
+            }
+        case r == '"' || r == '\'':
+            quote = r
+        case r == ',':
+            items = append(items, item)
+            item = ""
+            continue
+        }
+        item += string(r)
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unclosed quote in list: %s", s)
+    }
+    items = append(items, item)
+
+    for _, it := range items {
+        v, err := parseYamlScalar(strings.TrimSpace(it))
+        if err != nil {
+            return nil, err
+        }
+        if v != "" {
+            res = append(res, v)
+        }
+    }
+    return res, nil
+}
+
+func yamlFlowList(items []string) string {
+    quoted := []string{}
+    for _, item := range items {
+        quoted = append(quoted, yamlString(item))
+    }
+    return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// splitList splits a comma separated field like Tags or Links.
+func splitList(s string) []string {
+    res := []string{}
+    for _, part := range strings.Split(s, ",") {
+        part = strings.TrimSpace(part)
+        if part != "" {
+            res = append(res, part)
+        }
+    }
+    return res
+}
+
+// splitFrontMatter returns the lines between the --- delimiters and the
+// body after them, ok is false when text has no front matter.
+func splitFrontMatter(text string) ([]string, string, bool) {
+    lines := strings.SplitAfter(text, "\n")
+    if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterDelimiter {
+        return nil, text, false
+    }
+    for i := 1; i < len(lines); i++ {
+        if strings.TrimRight(lines[i], " \r\n") == frontMatterDelimiter {
+            return lines[1:i], strings.Join(lines[i + 1:], ""), true
+        }
+    }
+    return nil, text, false
+}
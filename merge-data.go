@@ -0,0 +1,431 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// `gaia admin merge-data <base> <ours> <theirs>` merges two data.json
+// copies that changed since base and writes the result to ours, which is
+// what git expects of a merge driver:
+//   git config merge.gaia.driver "gaia admin merge-data %O %A %B"
+//   echo "data.json merge=gaia" >> .gitattributes
+// Nodes merge field by field. A node both sides added under the same id
+// keeps the id on our side and gets a new one on theirs, the same goes
+// for category and branch prefixes both sides gave out. Content both sides
+// changed gets conflict markers, other fields keep our value, and the
+// merge exits non-zero so git leaves it marked as conflicted.
+
+type MergeConflict struct {
+    Id string
+    Name string
+    Field string
+    Reason string
+}
+
+type MergeReport struct {
+    Nodes int
+    Reissued map[string]string `json:",omitempty"` // old id -> new id
+    Renamed map[string]string `json:",omitempty"` // new id -> new name, names both sides added
+    Conflicts []MergeConflict
+}
+
+func readGaiaData(path string) (*GaiaData, error) {
+    bs, err := ioutil.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, err
+    }
+    data, _, err := migrateData(bs, path)
+    return data, err
+}
+
+func initGaiaData(data *GaiaData) {
+    if data.AliasMap == nil {
+        data.AliasMap = make(map[string]string)
+    }
+    if data.CategoryIdMap == nil {
+        data.CategoryIdMap = make(map[string]string)
+    }
+    if data.BranchIdMap == nil {
+        data.BranchIdMap = make(map[string]string)
+    }
+    if data.NameIdMap == nil {
+        data.NameIdMap = make(map[string]string)
+    }
+    if data.NodeMap == nil {
+        data.NodeMap = make(map[string]Node)
+    }
+    if data.CardMap == nil {
+        data.CardMap = make(map[string]CardState)
+    }
+}
+
+// mergeStringMaps merges per key, when both sides changed a key
+// differently ours wins and the key is returned as conflicting.
+func mergeStringMaps(base, ours, theirs map[string]string) (map[string]string, []string) {
+    res := make(map[string]string)
+    conflicts := []string{}
+    keys := make(map[string]bool)
+    for _, m := range []map[string]string{base, ours, theirs} {
+        for k := range m {
+            keys[k] = true
+        }
+    }
+    for k := range keys {
+        b, inBase := base[k]
+        o, inOurs := ours[k]
+        t, inTheirs := theirs[k]
+        switch {
+        case inOurs == inTheirs && o == t:
+            if inOurs {
+                res[k] = o
+            }
+        case inOurs == inBase && o == b:
+            if inTheirs {
+                res[k] = t
+            }
+        case inTheirs == inBase && t == b:
+            if inOurs {
+                res[k] = o
+            }
+        default:
+            conflicts = append(conflicts, k)
+            if inOurs {
+                res[k] = o
+            } else {
+                res[k] = t
+            }
+        }
+    }
+    sort.Strings(conflicts)
+    return res, conflicts
+}
+
+func conflictText(ours, theirs string) string {
+    return "<<<<<<< ours\n" + strings.TrimSuffix(ours, "\n") + "\n=======\n" + strings.TrimSuffix(theirs, "\n") + "\n>>>>>>> theirs"
+}
+
+// mergeNode merges the fields of a node both sides kept, base is the zero
+// Node when both added it.
+func mergeNode(base, ours, theirs Node, report *MergeReport) Node {
+    res := ours
+    b, o, t := reflect.ValueOf(base), reflect.ValueOf(ours), reflect.ValueOf(theirs)
+    r := reflect.ValueOf(&res).Elem()
+    for i := 0; i < b.NumField(); i++ {
+        field := b.Type().Field(i).Name
+        switch field {
+        case "Id", "Category", "Created", "Updated", "LastAccessed", "AccessCount":
+            continue
+        }
+        bf, of, tf := b.Field(i).Interface(), o.Field(i).Interface(), t.Field(i).Interface()
+        switch {
+        case reflect.DeepEqual(of, tf), reflect.DeepEqual(tf, bf):
+        case reflect.DeepEqual(of, bf):
+            r.Field(i).Set(t.Field(i))
+        default:
+            reason := "changed on both sides, kept ours"
+            if field == "Content" && !ours.Secret && !theirs.Secret {
+                res.Content = conflictText(ours.Content, theirs.Content)
+                reason = "changed on both sides, see the conflict markers"
+            }
+            report.Conflicts = append(report.Conflicts, MergeConflict{ours.Id, ours.Name, field, reason})
+        }
+    }
+    res.Category = strings.Split(res.Name, "-")[0]
+    // Updated takes the later side, Created the earlier, usage is not
+    // committed.
+    if theirs.Updated.After(res.Updated) {
+        res.Updated = theirs.Updated
+    }
+    if res.Created.IsZero() || !theirs.Created.IsZero() && theirs.Created.Before(res.Created) {
+        res.Created = theirs.Created
+    }
+    return res
+}
+
+// mergeSource tells on which sides a merged node has its id, and whether
+// its Links are those of theirs, links follow the moves of that side.
+type mergeSource struct {
+    ours bool
+    theirs bool
+    theirsLinks bool
+}
+
+type movedNode struct {
+    node Node
+    source mergeSource
+}
+
+// linksFromTheirs tells whether mergeNode took the Links of theirs.
+func linksFromTheirs(base, ours, theirs Node) bool {
+    return ours.Links == base.Links && theirs.Links != base.Links
+}
+
+// mergeGaiaData is the three way merge of merge-data, see above.
+func mergeGaiaData(base, ours, theirs *GaiaData) (*GaiaData, MergeReport) {
+    report := MergeReport{Reissued: map[string]string{}, Renamed: map[string]string{}}
+    merged := &GaiaData{}
+    initGaiaData(merged)
+
+    var conflicts []string
+    merged.AliasMap, conflicts = mergeStringMaps(base.AliasMap, ours.AliasMap, theirs.AliasMap)
+    for _, k := range conflicts {
+        report.Conflicts = append(report.Conflicts, MergeConflict{Field: "Alias", Name: k, Reason: "changed on both sides, kept ours"})
+    }
+
+    // nodes by id, theirs moves aside when the id means another node.
+    ids := make(map[string]bool)
+    for _, data := range []*GaiaData{base, ours, theirs} {
+        for id := range data.NodeMap {
+            ids[id] = true
+        }
+    }
+    sortedIds := []string{}
+    for id := range ids {
+        sortedIds = append(sortedIds, id)
+    }
+    sort.Strings(sortedIds)
+
+    // where each node has its id, ids mean other nodes on the two sides
+    // once nodes move, and the side its Links came from.
+    sources := make(map[string]mergeSource)
+    moved := []movedNode{} // nodes that need a new id
+    for _, id := range sortedIds {
+        b, inBase := base.NodeMap[id]
+        o, inOurs := ours.NodeMap[id]
+        t, inTheirs := theirs.NodeMap[id]
+        switch {
+        case !inBase && inOurs && inTheirs && o.Name != t.Name:
+            merged.NodeMap[id] = o
+            sources[id] = mergeSource{ours: true}
+            moved = append(moved, movedNode{t, mergeSource{theirs: true, theirsLinks: true}})
+        case !inBase && inOurs && inTheirs:
+            merged.NodeMap[id] = mergeNode(Node{}, o, t, &report)
+            sources[id] = mergeSource{true, true, linksFromTheirs(Node{}, o, t)}
+        case !inBase && inOurs:
+            merged.NodeMap[id] = o
+            sources[id] = mergeSource{ours: true}
+        case !inBase && inTheirs:
+            merged.NodeMap[id] = t
+            sources[id] = mergeSource{theirs: true, theirsLinks: true}
+        case inOurs && inTheirs:
+            merged.NodeMap[id] = mergeNode(b, o, t, &report)
+            sources[id] = mergeSource{true, true, linksFromTheirs(b, o, t)}
+        case inOurs && !reflect.DeepEqual(o, b):
+            merged.NodeMap[id] = o
+            sources[id] = mergeSource{ours: true, theirs: true}
+            report.Conflicts = append(report.Conflicts, MergeConflict{id, o.Name, "", "removed by theirs, changed by ours, kept"})
+        case inTheirs && !reflect.DeepEqual(t, b):
+            merged.NodeMap[id] = t
+            sources[id] = mergeSource{true, true, true}
+            report.Conflicts = append(report.Conflicts, MergeConflict{id, t.Name, "", "removed by ours, changed by theirs, kept"})
+        }
+    }
+
+    // prefixes: ours keeps what both gave out, their categories and
+    // branches get new prefixes with their nodes.
+    merged.CategoryIdMap = mergePrefixMap(base.CategoryIdMap, ours.CategoryIdMap, theirs.CategoryIdMap)
+    merged.BranchIdMap = mergePrefixMap(base.BranchIdMap, ours.BranchIdMap, theirs.BranchIdMap)
+
+    adoptPrefixes(merged)
+
+    store := &JsonFileStore{"", merged}
+    moveAside := func(id string) {
+        moved = append(moved, movedNode{merged.NodeMap[id], sources[id]})
+        delete(merged.NodeMap, id)
+        delete(sources, id)
+    }
+    for _, id := range sortedNodeIds(merged) {
+        if !idFits(merged.NodeMap[id], id, merged) {
+            moveAside(id)
+        }
+    }
+
+    // names stay unique, their node moves aside when both added a name.
+    for _, id := range sortedNodeIds(merged) {
+        name := merged.NodeMap[id].Name
+        if other, taken := merged.NameIdMap[name]; taken {
+            loser := id
+            if !sources[other].ours {
+                loser = other
+                merged.NameIdMap[name] = id
+            }
+            moveAside(loser)
+            continue
+        }
+        merged.NameIdMap[name] = id
+    }
+    sort.Slice(moved, func(i, j int) bool { return moved[i].node.Name < moved[j].node.Name })
+    oursRemap := make(map[string]string) // old id on our side -> new id
+    theirsRemap := make(map[string]string)
+    for _, m := range moved {
+        node, oldId := m.node, m.node.Id
+        renamed := false
+        if _, taken := merged.NameIdMap[node.Name]; taken {
+            node.Name = renameForConflict(node.Name, func(name string) bool { return merged.NameIdMap[name] != "" })
+            renamed = true
+        }
+        newId, err := store.generateId(node.Name)
+        if err != nil {
+            report.Conflicts = append(report.Conflicts, MergeConflict{oldId, node.Name, "Id", "dropped: " + err.Error()})
+            continue
+        }
+        node.Id = newId
+        node.Category = strings.Split(node.Name, "-")[0]
+        merged.NodeMap[newId] = node
+        merged.NameIdMap[node.Name] = newId
+        sources[newId] = m.source
+        if m.source.ours {
+            oursRemap[oldId] = newId
+        }
+        if m.source.theirs {
+            theirsRemap[oldId] = newId
+        }
+        report.Reissued[oldId] = newId
+        if renamed {
+            report.Renamed[newId] = node.Name
+        }
+    }
+
+    for id, node := range merged.NodeMap {
+        remap := oursRemap
+        if sources[id].theirsLinks {
+            remap = theirsRemap
+        }
+        links := []string{}
+        for _, link := range splitList(node.Links) {
+            if remap[link] != "" {
+                link = remap[link]
+            }
+            links = append(links, link)
+        }
+        node.Links = strings.Join(links, ",")
+        merged.NodeMap[id] = node
+    }
+
+    // review schedules: the later review wins.
+    for _, side := range []struct {
+        data *GaiaData
+        remap map[string]string
+    }{{ours, oursRemap}, {theirs, theirsRemap}} {
+        for id, state := range side.data.CardMap {
+            if newId := side.remap[id]; newId != "" {
+                id = newId
+            }
+            if _, exist := merged.NodeMap[id]; !exist {
+                continue
+            }
+            if current, ok := merged.CardMap[id]; !ok || state.LastReviewed.After(current.LastReviewed) {
+                merged.CardMap[id] = state
+            }
+        }
+    }
+
+    report.Nodes = len(merged.NodeMap)
+    return merged, report
+}
+
+// mergePrefixMap merges a name -> prefix map, a prefix given to two names
+// stays with ours or base, the other name is dropped to get a new one.
+func mergePrefixMap(base, ours, theirs map[string]string) map[string]string {
+    merged, _ := mergeStringMaps(base, ours, theirs)
+    owner := make(map[string]string) // prefix -> name
+    names := []string{}
+    for name := range merged {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        prefix := merged[name]
+        if other, taken := owner[prefix]; taken {
+            loser := name
+            if ours[other] != prefix && base[other] != prefix {
+                loser, owner[prefix] = other, name
+            }
+            delete(merged, loser)
+            continue
+        }
+        owner[prefix] = name
+    }
+    return merged
+}
+
+// adoptPrefixes adds the prefixes of nodes whose category or branch is
+// missing in the maps, unless another name holds them.
+func adoptPrefixes(data *GaiaData) {
+    ids := []string{}
+    for id := range data.NodeMap {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    adopt := func(m map[string]string, name string, prefix string) {
+        if _, exist := m[name]; exist {
+            return
+        }
+        for _, p := range m {
+            if p == prefix {
+                return
+            }
+        }
+        m[name] = prefix
+    }
+    for _, id := range ids {
+        parts := strings.Split(data.NodeMap[id].Name, "-")
+        if len(id) > 0 {
+            adopt(data.CategoryIdMap, parts[0], id[:1])
+        }
+        if len(parts) > 1 && len(id) > 1 {
+            adopt(data.BranchIdMap, parts[0] + "-" + parts[1], id[:2])
+        }
+    }
+}
+
+// idFits checks the id against the prefixes of the node's category and
+// branch.
+func idFits(node Node, id string, data *GaiaData) bool {
+    parts := strings.Split(node.Name, "-")
+    prefix, ok := data.CategoryIdMap[parts[0]]
+    if !ok || !strings.HasPrefix(id, prefix) {
+        return false
+    }
+    if len(parts) > 1 {
+        branchPrefix, ok := data.BranchIdMap[parts[0] + "-" + parts[1]]
+        return ok && strings.HasPrefix(id, branchPrefix)
+    }
+    return true
+}
+
+// mergeDataFiles runs the merge and writes the result to ours.
+func mergeDataFiles(basePath, oursPath, theirsPath string) (MergeReport, error) {
+    datas := []*GaiaData{}
+    for _, path := range []string{basePath, oursPath, theirsPath} {
+        data, err := readGaiaData(path)
+        if err != nil {
+            return MergeReport{}, err
+        }
+        datas = append(datas, data)
+    }
+    merged, report := mergeGaiaData(datas[0], datas[1], datas[2])
+    merged.Version = dataVersion
+    bs, err := json.MarshalIndent(merged, "", "  ")
+    if err != nil {
+        return report, err
+    }
+    return report, ioutil.WriteFile(oursPath, bs, 0660)
+}
+
+func (conflict MergeConflict) String() string {
+    res := conflict.Name
+    if conflict.Id != "" {
+        res += "(" + conflict.Id + ")"
+    }
+    if conflict.Field != "" {
+        res += " " + conflict.Field
+    }
+    return fmt.Sprintf("%s: %s", res, conflict.Reason)
+}
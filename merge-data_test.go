@@ -0,0 +1,120 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "testing"
+)
+
+// testData builds GaiaData of "id name links" lines, the prefixes are
+// taken from the ids.
+func testData(lines ...string) *GaiaData {
+    data := &GaiaData{}
+    initGaiaData(data)
+    for _, line := range lines {
+        fields := strings.Fields(line)
+        node := Node{Id: fields[0], Name: fields[1], Category: strings.Split(fields[1], "-")[0]}
+        if len(fields) > 2 {
+            node.Links = fields[2]
+        }
+        data.NodeMap[node.Id] = node
+        data.NameIdMap[node.Name] = node.Id
+    }
+    adoptPrefixes(data)
+    return data
+}
+
+// linkedNames is name -> the names its links point to, "?" for dangling.
+func linkedNames(data *GaiaData) map[string]string {
+    res := make(map[string]string)
+    for _, node := range data.NodeMap {
+        names := []string{}
+        for _, link := range splitList(node.Links) {
+            if target, exist := data.NodeMap[link]; exist {
+                names = append(names, target.Name)
+            } else {
+                names = append(names, "?" + link)
+            }
+        }
+        if len(names) > 0 {
+            sort.Strings(names)
+            res[node.Name] = strings.Join(names, ",")
+        }
+    }
+    return res
+}
+
+func TestMergeGaiaDataLinks(t *testing.T) {
+    tests := []struct {
+        desc string
+        base, ours, theirs []string
+        want map[string]string
+    }{
+        {
+            "their node moved for its prefix links their moved nodes",
+            []string{"0000 foo-bar-a"},
+            []string{"0000 foo-bar-a", "1000 go-x-y", "0001 foo-bar-b"},
+            []string{"0000 foo-bar-a", "1000 py-x-y", "0001 foo-bar-c", "1001 py-x-z 1000,0001"},
+            map[string]string{"py-x-z": "foo-bar-c,py-x-y"},
+        },
+        {
+            "our links keep their ids when their node moves",
+            []string{},
+            []string{"1000 go-a-b", "1001 go-a-c 1000"},
+            []string{"1000 py-a-b", "1001 py-a-c 1000"},
+            map[string]string{"go-a-c": "go-a-b", "py-a-c": "py-a-b"},
+        },
+        {
+            "links theirs changed on a shared node follow their moved node",
+            []string{"0000 os-sh-a"},
+            []string{"0000 os-sh-a", "0001 os-sh-c"},
+            []string{"0000 os-sh-a 0001", "0001 os-sh-b"},
+            map[string]string{"os-sh-a": "os-sh-b"},
+        },
+        {
+            "links ours changed on a shared node stay",
+            []string{"0000 os-sh-a"},
+            []string{"0000 os-sh-a 0001", "0001 os-sh-c"},
+            []string{"0000 os-sh-a", "0001 os-sh-b"},
+            map[string]string{"os-sh-a": "os-sh-c"},
+        },
+        {
+            "both add a name, their node moves with the links to it",
+            []string{},
+            []string{"0000 os-sh-a"},
+            []string{"0001 os-sh-a", "0002 os-sh-b 0001"},
+            map[string]string{"os-sh-b": "os-sh-a_2"},
+        },
+    }
+    for _, test := range tests {
+        merged, report := mergeGaiaData(testData(test.base...), testData(test.ours...), testData(test.theirs...))
+        got := linkedNames(merged)
+        if len(got) != len(test.want) {
+            t.Errorf("%s: links %v, want %v", test.desc, got, test.want)
+            continue
+        }
+        for name, want := range test.want {
+            if got[name] != want {
+                t.Errorf("%s: %s links %s, want %s (reissued %v)", test.desc, name, got[name], want, report.Reissued)
+            }
+        }
+        if issues := fsckData(merged, false, func(string) bool { return true }); len(issues) > 0 {
+            t.Errorf("%s: fsck issues %v", test.desc, issues)
+        }
+    }
+}
+
+func TestMergeGaiaDataCards(t *testing.T) {
+    base := testData()
+    ours := testData("1000 go-a-b")
+    theirs := testData("1000 py-a-b", "1001 py-a-c")
+    theirs.CardMap["1001"] = CardState{Reviews: 3}
+    merged, _ := mergeGaiaData(base, ours, theirs)
+    id := merged.NameIdMap["py-a-c"]
+    if merged.CardMap[id].Reviews != 3 {
+        t.Errorf("card of py-a-c(%s) lost: %v", id, merged.CardMap)
+    }
+    if _, exist := merged.CardMap[merged.NameIdMap["go-a-b"]]; exist {
+        t.Errorf("go-a-b got a card")
+    }
+}
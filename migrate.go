@@ -0,0 +1,159 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "strings"
+)
+
+// data.json carries the Version of its layout. A file without one is
+// version 0. On load an older file is upgraded step by step, each step
+// working on the decoded json so it can read shapes GaiaData no longer
+// has, a copy of the old file is kept as data.json.v<old>.bk first. A file
+// of a newer version is refused, this gaia would drop what it does not
+// know. `gaia admin migrate --check` shows the steps without writing.
+const dataVersion = 2
+
+type dataMigration struct {
+    Version int // the version the step upgrades to
+    Desc string
+    Apply func(data map[string]interface{}) []string // returns the changes
+}
+
+var dataMigrations = []dataMigration{
+    {1, "add missing maps, set Category to the first name part", migrateToV1},
+    {2, "nodes get Created, Updated, Author, LastAccessed and AccessCount, unknown for old nodes", migrateToV2},
+}
+
+type MigrationStep struct {
+    Version int
+    Desc string
+    Changes []string
+}
+
+type MigrationReport struct {
+    File string
+    From int
+    To int
+    Steps []MigrationStep
+    Backup string `json:",omitempty"`
+    Written bool
+}
+
+// migrateToV1: files from before versioning may miss maps, like
+// BranchIdMap, and have nodes without Category.
+func migrateToV1(data map[string]interface{}) []string {
+    changes := []string{}
+    for _, key := range []string{"AliasMap", "CategoryIdMap", "BranchIdMap", "NameIdMap", "NodeMap"} {
+        if m, ok := data[key].(map[string]interface{}); !ok || m == nil {
+            data[key] = map[string]interface{}{}
+            changes = append(changes, "add " + key)
+        }
+    }
+    nodes := data["NodeMap"].(map[string]interface{})
+    ids := []string{}
+    for id := range nodes {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+        node, ok := nodes[id].(map[string]interface{})
+        if !ok {
+            continue
+        }
+        name, _ := node["Name"].(string)
+        category := strings.Split(name, "-")[0]
+        if old, _ := node["Category"].(string); old != category {
+            node["Category"] = category
+            changes = append(changes, fmt.Sprintf("%s(%s): Category %q -> %q", name, id, old, category))
+        }
+    }
+    return changes
+}
+
+// migrateToV2 changes nothing, the version keeps older gaia from writing
+// the file without the new node fields.
+func migrateToV2(data map[string]interface{}) []string {
+    return []string{}
+}
+
+// migrateData decodes a data file, upgrades it to dataVersion and returns
+// it with the steps taken. path only names the file in errors.
+func migrateData(bs []byte, path string) (*GaiaData, MigrationReport, error) {
+    report := MigrationReport{File: path, From: dataVersion, To: dataVersion, Steps: []MigrationStep{}}
+    data := &GaiaData{}
+    if len(strings.TrimSpace(string(bs))) == 0 {
+        initGaiaData(data)
+        data.Version = dataVersion
+        return data, report, nil
+    }
+
+    raw := map[string]interface{}{}
+    if err := json.Unmarshal(bs, &raw); err != nil {
+        return nil, report, newCodedError(ERR_INVALID, "can not read " + path + ": " + err.Error())
+    }
+    version := 0
+    if v, exist := raw["Version"]; exist {
+        f, ok := v.(float64)
+        if !ok || f != float64(int(f)) || f < 0 {
+            return nil, report, newCodedError(ERR_INVALID, fmt.Sprintf("can not read %s: bad Version %v", path, v))
+        }
+        version = int(f)
+    }
+    report.From = version
+    if version > dataVersion {
+        return nil, report, newCodedError(ERR_INVALID, fmt.Sprintf("%s has version %d, this gaia only knows up to %d, please upgrade gaia", path, version, dataVersion))
+    }
+
+    for _, migration := range dataMigrations {
+        if migration.Version <= version {
+            continue
+        }
+        changes := migration.Apply(raw)
+        raw["Version"] = migration.Version
+        report.Steps = append(report.Steps, MigrationStep{migration.Version, migration.Desc, changes})
+    }
+    if len(report.Steps) > 0 {
+        var err error
+        if bs, err = json.Marshal(raw); err != nil {
+            return nil, report, err
+        }
+    }
+    if err := json.Unmarshal(bs, data); err != nil {
+        return nil, report, newCodedError(ERR_INVALID, "can not read " + path + ": " + err.Error())
+    }
+    initGaiaData(data)
+    return data, report, nil
+}
+
+// migrateDataFile upgrades the file in place, check only reports.
+func migrateDataFile(path string, check bool) (MigrationReport, error) {
+    bs, err := ioutil.ReadFile(path)
+    if err != nil {
+        return MigrationReport{}, err
+    }
+    data, report, err := migrateData(bs, path)
+    if err != nil || check || len(report.Steps) == 0 {
+        return report, err
+    }
+    if report.Backup, err = backupDataFile(path, bs, report.From); err != nil {
+        return report, err
+    }
+    store := &JsonFileStore{path, data}
+    applyNodeUsage(data, readNodeUsage(store.usagePath()))
+    if err := store.saveToFile(); err != nil {
+        return report, err
+    }
+    report.Written = true
+    return report, nil
+}
+
+func backupDataFile(path string, bs []byte, version int) (string, error) {
+    backup := fmt.Sprintf("%s.v%d.bk", path, version)
+    if err := ioutil.WriteFile(backup, bs, 0660); err != nil {
+        return "", fmt.Errorf("can not back up %s before migrating: %v", path, err)
+    }
+    return backup, nil
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+    "encoding/json"
     "fmt"
     "strings"
-    "os"
-    "bufio"
+    "time"
 )
 
 type Node struct {
@@ -18,6 +18,31 @@ type Node struct {
     ExecFile string
     Attachments []string // file path array.
     Links string // comma seperated node ids.
+    Secret bool `json:"Secret,omitempty"` // Content is sealed, see secret.go
+    Created time.Time // Created and Updated are set by the store
+    Updated time.Time
+    Author string `json:",omitempty"`
+    LastAccessed time.Time `json:"-"` // of the last get or exec, see Store.Touch
+    AccessCount int `json:"-"` // usage is kept in usage.json, see NodeUsage
+}
+
+// MarshalJSON leaves out Created and Updated when they are unknown, as for
+// nodes from before these fields, instead of writing year 1.
+func (node Node) MarshalJSON() ([]byte, error) {
+    type plainNode Node
+    return json.Marshal(struct {
+        plainNode
+        Created *time.Time `json:",omitempty"`
+        Updated *time.Time `json:",omitempty"`
+    }{plainNode(node), optionalTime(node.Created), optionalTime(node.Updated)})
+}
+
+// optionalTime is nil for the zero time, for json fields with omitempty.
+func optionalTime(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+    return &t
 }
 
 var CodePrefixSpace string = "    " // indent: 4
@@ -58,6 +83,12 @@ func (node Node) StringWithoutEmpty() string {
     if node.Desc != "" {
         res += fmt.Sprintf("      DESC: %s\n", node.Desc)
     }
+    if node.Author != "" {
+        res += fmt.Sprintf("    AUTHOR: %s\n", node.Author)
+    }
+    if !node.Updated.IsZero() {
+        res += fmt.Sprintf("   UPDATED: %s\n", node.Updated.Local().Format(nodeTimeLayout))
+    }
     codeLines := strings.Split(node.Content, "\n")
     for i, line := range codeLines {
         if i == 0 {
@@ -95,6 +126,18 @@ func (node Node) PrintToScreen() {
     fmt.Println(node.String())
 }
 
+// checkNodeName keeps names usable as file names, the git store and
+// export name files after nodes.
+func checkNodeName(name string) error {
+    if name == "" {
+        return newCodedError(ERR_INVALID, "node name is empty")
+    }
+    if strings.ContainsAny(name, "/\\\x00") || strings.Contains(name, "..") {
+        return newCodedError(ERR_INVALID, "invalid node name " + name + ", names can not hold /, \\ or ..")
+    }
+    return nil
+}
+
 func (node *Node) Normalize(aliasMap map[string]string) error {
     normalizeStr := func(s string, sep string) string {
         result := strings.ToLower(strings.TrimSpace(s))
@@ -119,62 +162,23 @@ func (node *Node) Normalize(aliasMap map[string]string) error {
     node.Category = normalizeStr(node.Category, "")
     node.Tags = normalizeStr(node.Tags, ",")
     node.Desc = strings.TrimSpace(node.Desc)
-    node.Content = strings.TrimSpace(node.Content)
+    node.Content = trimBlankLines(node.Content)
     return nil
 }
 
-func (node *Node) ReadFromFile(fpath string) error {
-    f, err := os.Open(fpath)
-    if err != nil {
-        return err
-    }
-    defer f.Close()
-
-    isCodeLine := false
-    isDescLine := false
-    scanner := bufio.NewScanner(f)
-    for scanner.Scan() {
-        rawLine := scanner.Text()
-        line := strings.TrimSpace(rawLine)
-        if strings.HasPrefix(line, "ID:") {
-            node.Id = line[len("ID:"):]
-        } else if strings.HasPrefix(line, "NAME:") {
-            node.Name = line[len("NAME:"):]
-        } else if strings.HasPrefix(line, "CATEGORY:") {
-            node.Category = line[len("CATEGORY:"):]
-        } else if strings.HasPrefix(line, "TAGS:") {
-            node.Tags = line[len("TAGS:"):]
-        } else if strings.HasPrefix(line, "EXECUTABLE:") {
-            executableStr := line[len("EXECUTABLE:"):]
-            if strings.EqualFold(executableStr, "true") {
-                node.Executable = true
-            } else {
-                node.Executable = false
-            }
-        } else if strings.HasPrefix(line, "EXECFILE:") {
-            node.ExecFile = line[len("EXECFILE:"):]
-        } else if strings.HasPrefix(line, "DESC:") {
-            node.Desc = line[len("DESC:"):]
-            isDescLine = true
-            isCodeLine = false
-        } else if strings.HasPrefix(line, "CONTENT:") {
-            node.Content = line[len("CONTENT:"):]
-            isCodeLine = true
-            isDescLine = false
-        } else {
-            if isDescLine {
-                node.Desc = node.Desc + "\n" + line
-            }
-            if isCodeLine {
-                codeLine := line
-                if strings.HasPrefix(rawLine, CodePrefixSpace) {
-                    codeLine = strings.TrimPrefix(rawLine, CodePrefixSpace)
-                }
-                node.Content = node.Content + "\n" + codeLine
-            }
+// trimBlankLines trims surrounding blank lines and trailing space, but keeps
+// the indentation of the first line.
+func trimBlankLines(s string) string {
+    s = strings.TrimRight(s, " \t\r\n")
+    for {
+        index := strings.Index(s, "\n")
+        if index < 0 || strings.TrimSpace(s[:index]) != "" {
+            break
         }
+        s = s[index + 1:]
     }
-
-    node.Content = strings.TrimPrefix(node.Content, "\n")
-    return node.Normalize(map[string]string{})
+    if strings.TrimSpace(s) == "" {
+        return ""
+    }
+    return s
 }
@@ -1,24 +1,144 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
+    "crypto/ed25519"
+    "encoding/base64"
     "errors"
     "fmt"
+    "io"
     "io/ioutil"
     "os"
     "os/exec"
+    "sort"
     "strings"
+    "time"
     "github.com/satori/go.uuid"
 )
 
 const resultDelimiter = "--------------------------------------------------------"
 
+// Operator prints text, or only collects data for printResult when
+// format is json or yaml.
 type Operator struct {
     err   error
     store Store
+    format string
+    data interface{}
+    editor string
+    clipboard ClipboardProvider // detected on first use when nil
+    clipboardName string // the configured provider, see detectClipboard
+    passphrase string // asked once per run, see secret.go
+    passphraseFile string
+    author string // Author of the config, resolved by the first addNode
+}
+
+type SearchResult struct {
+    Keywords []string
+    Category string
+    Total int
+    Nodes []Node
 }
 
 func newOperator(store Store) *Operator {
-    return &Operator{nil, store}
+    return &Operator{store: store, format: TEXT_OUTPUT, editor: defaultEditor}
+}
+
+func (op *Operator) isText() bool {
+    return op.format == TEXT_OUTPUT
+}
+
+func (op *Operator) getClipboard() (ClipboardProvider, error) {
+    if op.clipboard == nil {
+        cb, err := detectClipboard(op.clipboardName, os.Getenv, exec.LookPath)
+        if err != nil {
+            return nil, err
+        }
+        op.clipboard = cb
+    }
+    return op.clipboard, nil
+}
+
+// PasteClipboard returns the clipboard text, an empty clipboard is an error.
+func (op *Operator) PasteClipboard() (string, error) {
+    cb, err := op.getClipboard()
+    if err != nil {
+        return "", err
+    }
+    text, err := cb.Paste()
+    if err != nil {
+        return "", err
+    }
+    if strings.TrimSpace(text) == "" {
+        return "", newCodedError(ERR_INVALID, "clipboard is empty")
+    }
+    return text, nil
+}
+
+func (op *Operator) copyToClipboard(id string, anchor string, text string) {
+    cb, err := op.getClipboard()
+    if err == nil {
+        err = cb.Copy(text)
+    }
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.data = map[string]interface{}{"Id": id, "Anchor": anchor, "Clipboard": cb.Name(), "Bytes": len(text)}
+    if op.isText() {
+        fmt.Fprintf(os.Stderr, "copied %d bytes to clipboard (%s)\n", len(text), cb.Name())
+    }
+}
+
+// getPassphrase reads the passphrase of secret nodes once, asking twice
+// when confirm is set and it has to be typed.
+func (op *Operator) getPassphrase(confirm bool) (string, error) {
+    if op.passphrase != "" {
+        return op.passphrase, nil
+    }
+    var err error
+    if env := os.Getenv(secretPassphraseEnv); env != "" {
+        op.passphrase = env
+    } else if op.passphraseFile != "" {
+        op.passphrase, err = passphraseFromFile(op.passphraseFile)
+    } else {
+        op.passphrase, err = readPassphrase("passphrase: ", confirm)
+    }
+    return op.passphrase, err
+}
+
+// seal encrypts the content of a secret node that is not sealed yet.
+func (op *Operator) seal(node Node) (Node, error) {
+    if !node.Secret || isSealed(node.Content) {
+        return node, nil
+    }
+    passphrase, err := op.getPassphrase(true)
+    if err == nil {
+        node.Content, err = sealSecret(node.Content, passphrase)
+    }
+    return node, err
+}
+
+// unlock returns node with its content decrypted.
+func (op *Operator) unlock(node Node) (Node, error) {
+    if !node.Secret || !isSealed(node.Content) {
+        return node, nil
+    }
+    passphrase, err := op.getPassphrase(false)
+    if err == nil {
+        node.Content, err = openSecret(node.Content, passphrase)
+    }
+    return node, err
+}
+
+// getUnlocked is GetById for commands that read the content.
+func (op *Operator) getUnlocked(id string) (Node, error) {
+    node, err := op.store.GetById(id)
+    if err != nil {
+        return node, err
+    }
+    return op.unlock(node)
 }
 
 func (op *Operator) Add(node Node) {
@@ -26,7 +146,29 @@ func (op *Operator) Add(node Node) {
         return
     }
 
-    op.err = op.store.Add(node)
+    node, op.err = op.seal(node)
+    if op.err != nil {
+        return
+    }
+    id, err := op.addNode(node)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if op.isText() {
+        fmt.Println("generate new node id:", id)
+    }
+    op.data, op.err = op.store.GetById(id)
+}
+
+// addNode is store.Add for every new node, it sets Author where the node
+// brings none.
+func (op *Operator) addNode(node Node) (string, error) {
+    if node.Author == "" {
+        op.author = nodeAuthor(op.author)
+        node.Author = op.author
+    }
+    return op.store.Add(node)
 }
 
 func (op *Operator) AddAlias(from, to string) {
@@ -35,6 +177,7 @@ func (op *Operator) AddAlias(from, to string) {
     }
 
     op.err = op.store.AddAlias(from, to)
+    op.data = map[string]string{"From": from, "To": to}
 }
 
 func (op *Operator) RemoveAlias(keyword string) {
@@ -43,35 +186,66 @@ func (op *Operator) RemoveAlias(keyword string) {
     }
 
     op.err = op.store.RemoveAlias(keyword)
+    op.data = map[string]string{"Removed": keyword}
 }
 
 func (op *Operator) Update(node Node) {
     if node.Id == "" {
-        op.err = errors.New("id is empty")
+        op.err = newCodedError(ERR_INVALID, "id is empty")
         return
     }
 
+    if node, op.err = op.seal(node); op.err != nil {
+        return
+    }
     op.err = op.store.Update(node)
+    if op.err == nil {
+        op.data, op.err = op.store.GetById(node.Id)
+    }
 }
 
 func (op *Operator) Append(id string, extraContent string) {
     if id == "" || extraContent == "" {
-        op.err = errors.New("id or content is nil")
+        op.err = newCodedError(ERR_INVALID, "id or content is nil")
         return
     }
 
+    // the store appends to the sealed text, secret nodes are appended here.
+    if node, err := op.store.GetById(id); err == nil && node.Secret {
+        if node, op.err = op.unlock(node); op.err == nil {
+            node.Content = strings.TrimSpace(node.Content) + "\n" + extraContent
+            op.Update(node)
+        }
+        return
+    }
     op.err = op.store.Append(id, extraContent)
+    if op.err == nil {
+        op.data, op.err = op.store.GetById(id)
+    }
 }
 
-func (op *Operator) Search(category string, keywords []string) {
+// Search leaves out secret nodes unless unlocked, sortBy is usage, recent
+// or empty.
+func (op *Operator) Search(category string, keywords []string, unlocked bool, sortBy string) {
+    keywordsReplaced := op.store.ReplaceAlias(keywords)
+    matchedNode := op.store.Search(category, keywordsReplaced)
+    if !unlocked {
+        matchedNode = withoutSecrets(matchedNode)
+    }
+    if op.err = sortNodes(matchedNode, sortBy); op.err != nil {
+        return
+    }
+    op.data = SearchResult{keywordsReplaced, category, len(matchedNode), matchedNode}
+    if !op.isText() {
+        return
+    }
+
     if category == "" {
         fmt.Println("Search nodes with keywords:", keywords)
     } else {
         fmt.Println("Search nodes with keywords:", keywords, "in category:", category)
     }
 
-    keywordsReplaced := op.store.ReplaceAlias(keywords)
-    matchedNode := op.store.Search(category, keywordsReplaced)
     size := len(matchedNode)
     if size == 0 {
         fmt.Println("None were found")
@@ -97,8 +271,9 @@ func (op *Operator) Remove(id string) {
         return
     }
     op.err = op.store.Remove(id)
+    op.data = map[string]string{"Removed": id}
 
-    if op.err == nil {
+    if op.err == nil && op.isText() {
         fmt.Println("node with id " + id + " has been removed")
     }
 }
@@ -118,12 +293,14 @@ func (op *Operator) Merge(ids []string) {
     var allTags []string
     var desc string
     var content string
+    secret := false
     for i, id := range ids {
-        node, err := op.store.GetById(id)
+        node, err := op.getUnlocked(id)
         if err != nil {
             op.err = err
             return
         }
+        secret = secret || node.Secret
 
         if i == 0 {
             cate = node.Category
@@ -151,15 +328,19 @@ func (op *Operator) Merge(ids []string) {
         Tags: allTagsStr,
         Desc: desc,
         Content: content,
+        Secret: secret,
     }
     for _, id := range ids {
         op.Remove(id)
     }
     op.Add(mergedNode)
+    if op.err == nil {
+        op.data = map[string]interface{}{"Merged": ids, "Node": op.data}
+    }
 }
 
 func (op *Operator) Edit(id string) {
-    node, err := op.store.GetById(id)
+    node, err := op.getUnlocked(id)
     oldName := node.Name
 
     if err != nil {
@@ -167,60 +348,157 @@ func (op *Operator) Edit(id string) {
         return
     }
 
-    tmpDir := os.TempDir()
-    uuidObj, err := uuid.NewV4()
-    tmpFileName := uuidObj.String()
-    tmpFile, err := ioutil.TempFile(tmpDir, tmpFileName)
-    if err != nil {
+    // a taken name reopens the editor, the store would refuse it after the
+    // buffer is gone.
+    validate := func(edited Node) error {
+        (&edited).Normalize(op.store.GetAlias())
+        if op.nameTaken(edited.Name, id) {
+            return errors.New("name " + edited.Name + " is taken by another node")
+        }
+        return nil
+    }
+    node, changed, err := op.editInEditor(node, validate)
+    if err != nil || !changed {
         op.err = err
         return
     }
-    defer tmpFile.Close()
-    tmpFile.WriteString(node.String())
+    node.Id = id
 
-    path, err := exec.LookPath("vi")
-    if err != nil {
-        op.err = errors.New("Error while looking for vi: " + err.Error())
+    oldCategory := strings.Split(oldName, "-")[0]
+    newCategory := strings.Split(node.Name, "-")[0]
+    if oldCategory == newCategory {
+        op.Update(node)
+    } else {
+        op.Add(node)
+        added := op.data
+        op.Remove(id)
+        op.data = added
+    }
+}
+
+// New adds a node written in the editor, starting from a template.
+func (op *Operator) New(name string) {
+    if op.err != nil {
         return
     }
 
-    cmd := exec.Command(path, tmpFile.Name())
-    cmd.Stdin = os.Stdin
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
+    template := Node{Name: name, Tags: "", Desc: ""}
+    validate := func(node Node) error {
+        (&node).Normalize(op.store.GetAlias())
+        if node.Name == "" {
+            return errors.New("name is empty")
+        }
+        if op.nameTaken(node.Name, "") {
+            return errors.New("name " + node.Name + " is taken by another node")
+        }
+        if node.Content == "" {
+            return errors.New("content is empty, write it below the front matter")
+        }
+        if node.Executable && node.ExecFile == "" {
+            return errors.New("exec_file is required for an executable node")
+        }
+        return nil
+    }
 
-    err = cmd.Start()
-    if err != nil {
+    node, changed, err := op.editInEditor(template, validate)
+    if err != nil || !changed {
         op.err = err
         return
     }
+    node.Category = strings.Split(node.Name, "-")[0]
+    op.Add(node)
+}
 
-    err = cmd.Wait()
-
-    // if err != nil {
-    //     op.err = err
-    //     return
-    // }
+// nameTaken tells if a node other than id has name.
+func (op *Operator) nameTaken(name string, id string) bool {
+    for _, other := range op.store.ListNodes([]string{name}) {
+        if other.Name == name && other.Id != id {
+            return true
+        }
+    }
+    return false
+}
 
-    //fmt.Println("tmpFile: ", tmpFile.Name())
-    err = (&node).ReadFromFile(tmpFile.Name())
+// editInEditor opens node as markdown and reopens the editor until the
+// buffer parses and passes validate. A failing editor aborts with an
+// error, a buffer saved back unchanged aborts with changed false.
+func (op *Operator) editInEditor(node Node, validate func(Node) error) (Node, bool, error) {
+    tmpDir := os.TempDir()
+    uuidObj, err := uuid.NewV4()
+    tmpFileName := uuidObj.String()
+    tmpFile, err := ioutil.TempFile(tmpDir, tmpFileName + "*" + editFileExtension(node))
     if err != nil {
-        op.err = err
-        return
+        return node, false, err
     }
+    defer os.Remove(tmpFile.Name())
+    originText := node.Markdown()
+    tmpFile.WriteString(originText)
+    tmpFile.Close()
 
-    oldCategory := strings.Split(oldName, "-")[0]
-    newCategory := strings.Split(node.Name, "-")[0]
-    if oldCategory == newCategory {
-        op.Update(node)
-    } else {
-        op.Add(node)
-        op.Remove(id)
+    lastText := originText
+    for {
+        err = runEditor(op.editor, tmpFile.Name())
+        if err != nil {
+            return node, false, err
+        }
+
+        bs, err := ioutil.ReadFile(tmpFile.Name())
+        if err != nil {
+            return node, false, err
+        }
+        text := removeEditErrorLines(string(bs))
+        if text == originText {
+            if op.isText() {
+                fmt.Println("no changes, edit aborted")
+            }
+            return node, false, nil
+        }
+        if text == lastText {
+            return node, false, newCodedError(ERR_INVALID, "edit aborted, buffer still has errors")
+        }
+
+        parsed := node
+        err = (&parsed).ParseMarkdown(text)
+        if err == nil && validate != nil {
+            err = validate(parsed)
+        }
+        if err == nil {
+            return parsed, true, nil
+        }
+        lastText = text
+        ioutil.WriteFile(tmpFile.Name(), []byte(addEditErrorLine(text, err)), 0600)
+    }
+}
+
+const editErrorPrefix = "# ERROR: "
+
+// addEditErrorLine puts the parse error as a comment on top of the front
+// matter, so the reopened editor shows it.
+func addEditErrorLine(text string, err error) string {
+    if strings.HasPrefix(text, frontMatterDelimiter + "\n") {
+        return frontMatterDelimiter + "\n" + editErrorPrefix + err.Error() + "\n" + text[len(frontMatterDelimiter) + 1:]
+    }
+    return frontMatterDelimiter + "\n" + editErrorPrefix + err.Error() + "\n" + frontMatterDelimiter + "\n" + text
+}
+
+func removeEditErrorLines(text string) string {
+    lines := strings.SplitAfter(text, "\n")
+    res := ""
+    for i, line := range lines {
+        if i > 0 && i < 3 && strings.HasPrefix(line, editErrorPrefix) {
+            continue
+        }
+        res += line
     }
+    return res
 }
 
 func (op *Operator) ListAlias() {
     aliasMap := op.store.GetAlias()
+    op.data = aliasMap
+    if !op.isText() {
+        return
+    }
 
     if len(aliasMap) == 0 {
         fmt.Println("No Alias Mapping")
@@ -234,6 +512,10 @@ func (op *Operator) ListAlias() {
 
 func (op *Operator) ListCates() {
     catesMap := op.store.ListCategories()
+    op.data = catesMap
+    if !op.isText() {
+        return
+    }
     treeNode := mapListToTree(catesMap, "Categories")
     treeNode.PrintToScreen(1);
 }
@@ -242,81 +524,997 @@ func (op *Operator) ListNodes(names []string) {
     namesPlaced := op.store.ReplaceAlias(names)
     nodeArray := op.store.ListNodes(namesPlaced)
     treeNode := nodesToTree(nodeArray, strings.Join(namesPlaced, "-"))
-    treeNode.PrintToScreen(1);
+    op.data = treeNode
+    if op.isText() {
+        treeNode.PrintToScreen(1);
+    }
 }
 
-func (op *Operator) ListTags() {
-    op.err = errors.New("not implemented yet.")
+// ListRecent lists the nodes below names, last added or updated first.
+func (op *Operator) ListRecent(names []string) {
+    nodes := recentNodes(op.store.ListNodes(op.store.ReplaceAlias(names)))
+    op.printActivity(nodes, "no nodes with a known time", func(node Node) string {
+        what := "updated"
+        if node.Updated.Equal(node.Created) || node.Updated.IsZero() {
+            what = "added"
+        }
+        return lastChanged(node).Local().Format(nodeTimeLayout) + "  " + what
+    })
 }
 
-func (op *Operator) Exec(file string) {
-    executor := newExecutor(file)
-    executor.Execute()
-}
-
-func (op *Operator) Get(id string, onlyContent bool) {
-    getAnchorContent := func(_content string, _anchor string) string {
-        _anchor = strings.TrimSpace(_anchor)
-
-        _contentLines := strings.Split(_content, "\n")
-        var anchorLines []string
-        isInAnchor := false
-        for _, line := range _contentLines {
-            lineTrimed := strings.TrimSpace(line)
-            if strings.HasPrefix(lineTrimed, _anchor + " ") {
-                isInAnchor = true
-            } else if strings.HasPrefix(lineTrimed, "#") {
-                if isInAnchor {
-                    isInAnchor = false
-                }
-            } else {
-                if isInAnchor {
-                    anchorLines = append(anchorLines, line)
-                }
-            }
+// ListStale lists the nodes not added, updated, got or executed within age.
+func (op *Operator) ListStale(age string, names []string) {
+    d, err := parseAge(age)
+    if err != nil {
+        op.err = err
+        return
+    }
+    nodes := staleNodes(op.store.ListNodes(op.store.ReplaceAlias(names)), time.Now().Add(-d))
+    op.printActivity(nodes, "no nodes unused for " + age, func(node Node) string {
+        if lastUsed(node).IsZero() {
+            return fmt.Sprintf("%-16s  %d uses", "never", node.AccessCount)
         }
-        return strings.Join(anchorLines, "\n")
+        return fmt.Sprintf("%s  %d uses", lastUsed(node).Local().Format(nodeTimeLayout), node.AccessCount)
+    })
+}
+
+func (op *Operator) printActivity(nodes []Node, empty string, when func(Node) string) {
+    rows := []NodeActivity{}
+    for _, node := range nodes {
+        rows = append(rows, nodeActivity(node))
+    }
+    op.data = rows
+    if !op.isText() {
+        return
     }
+    if len(nodes) == 0 {
+        fmt.Println(empty)
+    }
+    for _, node := range nodes {
+        fmt.Printf("%s  %s(%s)\n", when(node), node.Name, node.Id)
+    }
+}
+
+func (op *Operator) ListTags() {
+    op.err = newCodedError(ERR_NOT_IMPLEMENTED, "not implemented yet.")
+}
+
+// Exec runs a file, or a node when no such file exists. Template variables
+// not in values are prompted for.
+func (op *Operator) Exec(target string, options ExecOptions, values map[string]string) {
+    file := target
+    contentBs, err := ioutil.ReadFile(target)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            op.err = err
+            return
+        }
 
-    anchor := ""
-    anchorIndex := strings.Index(id, "#")
-    if anchorIndex > 0 {
-        anchor = id[anchorIndex:]
-        id = id[0 :  anchorIndex]
+        node, err := op.getUnlocked(target)
+        if err != nil {
+            op.err = err
+            return
+        }
+        if !node.Executable || node.ExecFile == "" {
+            op.err = newCodedError(ERR_INVALID, "node " + target + " is not executable")
+            return
+        }
+        if op.err = op.store.Touch(node.Id); op.err != nil {
+            return
+        }
+        file = node.ExecFile
+        contentBs = []byte(node.Content)
+        options.Private = options.Private || node.Secret
     }
+    op.execContent(file, string(contentBs), options, values)
+}
 
-    node, err := op.store.GetById(id)
+// ExecClipboard runs the clipboard as if it was saved to mainFile, whose
+// extension picks the language.
+func (op *Operator) ExecClipboard(mainFile string, options ExecOptions, values map[string]string) {
+    content, err := op.PasteClipboard()
     if err != nil {
         op.err = err
         return
     }
+    op.execContent(mainFile, content, options, values)
+}
 
-    if anchor != "" {
-        anchorContent := getAnchorContent(node.Content, anchor)
-        fmt.Println(anchorContent)
-    } else {
-        if onlyContent {
-            fmt.Println(node.Content)
-        } else {
-            fmt.Println(resultDelimiter)
-            fmt.Print(node.StringWithoutEmpty())
-            fmt.Println(resultDelimiter)
-        }
+// ExecResult is the data of exec outside text mode, the output of the
+// run is collected into it instead of printed.
+type ExecResult struct {
+    File string
+    Output string
+}
+
+func (op *Operator) execContent(file string, content string, options ExecOptions, values map[string]string) {
+    executor := newExecutor(file)
+    executor.ExecOptions = options
+
+    var prompt io.Writer = os.Stdout
+    if !op.isText() {
+        prompt = os.Stderr
+    }
+    vars := parseTemplateVars(content)
+    if err := promptTemplateVars(vars, values, os.Stdin, prompt); err != nil {
+        op.err = err
+        return
+    }
+    content, missing := renderTemplate(content, values)
+    if op.err = missingVarsError(missing); op.err != nil {
+        return
+    }
+    executor.Content = content
+    if op.isText() {
+        op.err = executor.Execute()
+        return
+    }
+
+    // stdout holds only the result, progress goes to stderr.
+    out := &bytes.Buffer{}
+    executor.Log, executor.Out = os.Stderr, out
+    if op.err = executor.Execute(); op.err != nil {
+        os.Stderr.Write(out.Bytes())
+        return
     }
+    op.data = ExecResult{file, out.String()}
 }
 
-func (op *Operator) Stats() {
-    stats := op.store.GetStats()
-    fmt.Println("Stats:")
-    fmt.Printf("    CategorySize: %d\n", stats.CategorySize)
-    fmt.Printf("    NodeSize:     %d\n", stats.NodeSize)
-    fmt.Printf("    TagSize:      %d\n", stats.TagSize)
+func (op *Operator) GcExecCache(maxSize int64) {
+    removed, freed, err := gcExecCache(maxSize, "")
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.data = map[string]int64{"Removed": int64(removed), "Freed": freed}
+    if op.isText() {
+        fmt.Printf("removed %d cached projects, freed %d bytes\n", removed, freed)
+    }
 }
 
-func (op *Operator) FormatData() {
-    op.store.FormatData()
+// Import adds every text file below dir as a node, see import.go.
+func (op *Operator) Import(dir string, options ImportOptions) {
+    if op.err != nil {
+        return
+    }
+
+    files, err := listImportFiles(dir)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    aliasMap := op.store.GetAlias()
+    planned := make(map[string]bool)
+    findByName := func(name string) (Node, bool) {
+        for _, n := range op.store.ListNodes([]string{name}) {
+            if n.Name == name {
+                return n, true
+            }
+        }
+        return Node{}, false
+    }
+    exists := func(name string) bool {
+        _, found := findByName(name)
+        return found || planned[name]
+    }
+
+    report := ImportReport{}
+    for _, rel := range files {
+        item := ImportItem{File: rel}
+        node, err := nodeFromFile(dir, rel, options)
+        if err != nil {
+            item.Status, item.Reason = "failed", err.Error()
+            report.add(item)
+            continue
+        }
+        (&node).Normalize(aliasMap)
+        item.Name = node.Name
+        if node.Content == "" {
+            item.Status, item.Reason = "skipped", "empty content"
+            report.add(item)
+            continue
+        }
+
+        item.Status = "added"
+        existing, found := findByName(node.Name)
+        if planned[node.Name] {
+            // an earlier file of this import has the name, overwriting it
+            // would hit the store before it knows the node.
+            if options.OnConflict == CONFLICT_SKIP {
+                item.Status, item.Reason = "skipped", "name repeats in this import"
+                report.add(item)
+                continue
+            }
+            node.Name = renameForConflict(node.Name, exists)
+            item.Name, item.Status = node.Name, "renamed"
+        } else if found {
+            switch options.OnConflict {
+            case CONFLICT_SKIP:
+                item.Status, item.Reason = "skipped", "name exists"
+                item.Id = existing.Id
+                report.add(item)
+                continue
+            case CONFLICT_RENAME:
+                node.Name = renameForConflict(node.Name, exists)
+                item.Name, item.Status = node.Name, "renamed"
+            case CONFLICT_OVERWRITE:
+                node.Id = existing.Id
+                node.Attachments = existing.Attachments
+                item.Id, item.Status = existing.Id, "overwritten"
+            }
+        }
+        planned[node.Name] = true
+
+        if !options.DryRun {
+            if item.Status == "overwritten" && node.Id != "" {
+                err = op.store.Update(node)
+            } else {
+                item.Id, err = op.addNode(node)
+            }
+            if err != nil {
+                item.Status, item.Reason = "failed", err.Error()
+            }
+        }
+        report.add(item)
+    }
+
+    op.data = report
+    if !op.isText() {
+        return
+    }
+    for _, item := range report.Items {
+        line := fmt.Sprintf("%-12s %s", item.Status, item.File)
+        if item.Name != "" {
+            line += " -> " + item.Name
+        }
+        if item.Id != "" {
+            line += "(" + item.Id + ")"
+        }
+        if item.Reason != "" {
+            line += ": " + item.Reason
+        }
+        fmt.Println(line)
+    }
+    fmt.Println(resultDelimiter)
+    if options.DryRun {
+        fmt.Println("dry run, nothing was written")
+    }
+    fmt.Printf("added: %d, renamed: %d, overwritten: %d, skipped: %d, failed: %d\n",
+        report.Added, report.Renamed, report.Overwritten, report.Skipped, report.Failed)
 }
 
-func (op *Operator) ReorgAllData() {
-    op.store.ReorgAllData()
+// Export writes the nodes matching options to target: a directory of
+// markdown files for md or a static site, a single file for pdf and html.
+func (op *Operator) Export(format string, target string, options ExportOptions) {
+    if op.err != nil {
+        return
+    }
+
+    nodes := filterExportNodes(op.store.ListNodes([]string{}), options)
+    for i := range nodes {
+        if nodes[i], op.err = op.unlock(nodes[i]); op.err != nil {
+            return
+        }
+    }
+    title := "Gaia"
+    if options.Category != "" {
+        title += " - " + options.Category
+    }
+    if options.Tag != "" {
+        title += " #" + options.Tag
+    }
+
+    var count int
+    switch format {
+    case "md":
+        existing := make(map[string]bool)
+        for _, node := range op.store.ListNodes([]string{}) {
+            existing[node.Id] = true
+        }
+        count, op.err = exportMarkdown(nodes, target, existing)
+        op.data = map[string]interface{}{"Format": format, "Dir": target, "Nodes": count}
+    case "pdf":
+        count, op.err = exportPdf(nodes, target, title)
+        op.data = map[string]interface{}{"Format": format, "File": target, "Nodes": count}
+    case "html":
+        count, op.err = exportHtml(nodes, target, title)
+        op.data = map[string]interface{}{"Format": format, "File": target, "Nodes": count}
+    case "site":
+        count, op.err = exportSite(nodes, op.store.ListCategories(), target)
+        op.data = map[string]interface{}{"Format": format, "Dir": target, "Nodes": count}
+    default:
+        op.err = newCodedError(ERR_USAGE, "unknown export format: " + format)
+        return
+    }
+
+    if op.err == nil && op.isText() {
+        fmt.Println("exported", count, "nodes to", target)
+    }
+}
+
+// ExportProject unpacks a node into dir, the main file is named by ExecFile.
+// Secret nodes are written out decrypted, and only with unlock.
+func (op *Operator) ExportProject(id string, dir string, unlock bool) {
+    if op.err != nil {
+        return
+    }
+
+    node, err := op.store.GetById(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if node.Secret && !unlock {
+        op.err = newCodedError(ERR_FORBIDDEN, "node " + id + " is secret, export it with --unlock")
+        return
+    }
+    if node, op.err = op.unlock(node); op.err != nil {
+        return
+    }
+
+    mainFile := node.ExecFile
+    if mainFile == "" {
+        mainFile = node.Name
+    }
+    filesMap, resultMainFile := unpackProject(mainFile, strings.NewReader(node.Content))
+    // an empty main file gives way to the first separated file, as in exec,
+    // so it is neither written nor counted.
+    if resultMainFile != mainFile && strings.TrimSpace(filesMap[mainFile]) == "" {
+        delete(filesMap, mainFile)
+    }
+    op.err = writeProject(dir, filesMap)
+    op.data = map[string]interface{}{"Id": id, "Dir": dir, "Main": resultMainFile, "Files": len(filesMap)}
+    if op.err == nil && op.isText() {
+        fmt.Println("exported", len(filesMap), "files to", dir)
+    }
+}
+
+// ImportProject packs the files below dir into the content of node.
+func (op *Operator) ImportProject(dir string, node Node) {
+    if op.err != nil {
+        return
+    }
+
+    filesMap, err := readProject(dir)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if len(filesMap) == 0 {
+        op.err = newCodedError(ERR_INVALID, "no files found in " + dir)
+        return
+    }
+
+    if node.ExecFile == "" {
+        candidates := []string{}
+        for name := range filesMap {
+            if name != MetaFile {
+                candidates = append(candidates, name)
+            }
+        }
+        if len(candidates) != 1 {
+            op.err = newCodedError(ERR_INVALID, "can not guess the main file, use -m, files: " + strings.Join(candidates, ", "))
+            return
+        }
+        node.ExecFile = candidates[0]
+    }
+    if _, exist := filesMap[node.ExecFile]; !exist {
+        op.err = newCodedError(ERR_NOT_FOUND, "main file not found in " + dir + ": " + node.ExecFile)
+        return
+    }
+
+    node.Content = packProject(node.ExecFile, filesMap)
+    op.Add(node)
+}
+
+// BundleCreate packs the nodes of options.Ids or options.Tag into a bundle
+// file, see bundle.go.
+func (op *Operator) BundleCreate(options BundleOptions) {
+    if op.err != nil {
+        return
+    }
+
+    // secret nodes go in only with --unlock, decrypted, the importer seals
+    // them with its own passphrase. --ids refuses them without, --tag
+    // leaves them out like export does.
+    nodes := []Node{}
+    bundled := make(map[string]bool)
+    for _, id := range options.Ids {
+        node, err := op.store.GetById(id)
+        if err != nil {
+            op.err = err
+            return
+        }
+        if node.Secret && !options.Unlock {
+            op.err = newCodedError(ERR_FORBIDDEN, "node " + id + " is secret, bundle it with --unlock")
+            return
+        }
+        if !bundled[node.Id] {
+            bundled[node.Id] = true
+            nodes = append(nodes, node)
+        }
+    }
+    if options.Tag != "" {
+        for _, node := range filterExportNodes(op.store.ListNodes([]string{}), ExportOptions{Tag: options.Tag, Unlock: options.Unlock}) {
+            if !bundled[node.Id] {
+                bundled[node.Id] = true
+                nodes = append(nodes, node)
+            }
+        }
+    }
+    if len(nodes) == 0 {
+        op.err = newCodedError(ERR_NOT_FOUND, "no nodes to bundle")
+        return
+    }
+    for i := range nodes {
+        if nodes[i], op.err = op.unlock(nodes[i]); op.err != nil {
+            return
+        }
+    }
+
+    var key ed25519.PrivateKey
+    if options.Sign {
+        if key, op.err = loadBundleKey(options.KeyFile); op.err != nil {
+            return
+        }
+    }
+    aliases := bundleAliases(nodes, op.store.GetAlias())
+    if op.err = writeBundle(options.Output, nodes, aliases, options.Author, key); op.err != nil {
+        return
+    }
+
+    data := map[string]interface{}{"File": options.Output, "Nodes": len(nodes), "Aliases": len(aliases), "Signed": key != nil}
+    if key != nil {
+        data["Fingerprint"] = keyFingerprint(key.Public().(ed25519.PublicKey))
+    }
+    op.data = data
+    if op.isText() {
+        fmt.Printf("bundled %d nodes and %d aliases into %s\n", len(nodes), len(aliases), options.Output)
+        if key != nil {
+            fmt.Println("signed by", data["Fingerprint"])
+        }
+    }
+}
+
+// BundleImportReport is the ImportReport of a bundle, File is the id a
+// node had in the bundle.
+type BundleImportReport struct {
+    Author string
+    Signer string `json:"Signer,omitempty"` // the TrustedKeys name
+    Fingerprint string `json:"Fingerprint,omitempty"`
+    Trusted bool
+    AliasesAdded int
+    AliasConflicts []string `json:"AliasConflicts,omitempty"`
+    ImportReport
+}
+
+// BundleImport adds the nodes of a bundle with new local ids, links between
+// them are rewritten to the new ids and attachments are saved below
+// ~/.gaia/attachments. With options.Verify only bundles signed by a
+// trusted key are imported.
+func (op *Operator) BundleImport(file string, options BundleOptions) {
+    if op.err != nil {
+        return
+    }
+
+    bundle, err := readBundle(file)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    report := BundleImportReport{Author: bundle.Manifest.Author}
+    if bundle.Signer != nil {
+        report.Fingerprint = keyFingerprint(bundle.Signer)
+        report.Signer, report.Trusted = trustedSigner(bundle.Signer, options.TrustedKeys)
+    }
+    if options.Verify && !report.Trusted {
+        if bundle.Signer == nil {
+            op.err = newCodedError(ERR_FORBIDDEN, "bundle is not signed")
+        } else {
+            op.err = newCodedError(ERR_FORBIDDEN, "bundle is signed by an unknown key " + report.Fingerprint +
+                ", add it to Bundle.TrustedKeys in " + configFilePath)
+        }
+        return
+    }
+
+    localAliases := op.store.GetAlias()
+    for from, to := range bundle.Aliases {
+        local, exist := localAliases[from]
+        if !exist {
+            if op.err = op.store.AddAlias(from, to); op.err != nil {
+                return
+            }
+            report.AliasesAdded++
+        } else if local != to {
+            report.AliasConflicts = append(report.AliasConflicts, from + " -> " + to + ", kept " + local)
+        }
+    }
+    sort.Strings(report.AliasConflicts)
+
+    aliasMap := op.store.GetAlias()
+    findByName := func(name string) (Node, bool) {
+        for _, n := range op.store.ListNodes([]string{name}) {
+            if n.Name == name {
+                return n, true
+            }
+        }
+        return Node{}, false
+    }
+    exists := func(name string) bool {
+        _, found := findByName(name)
+        return found
+    }
+
+    // add first, links need every new id.
+    idMap := make(map[string]string)
+    imported := make(map[int]int) // report item -> bundle node
+    for i, node := range bundle.Nodes {
+        item := ImportItem{File: node.Id}
+        (&node).Normalize(aliasMap)
+        item.Name, item.Status = node.Name, "added"
+        if existing, found := findByName(node.Name); found {
+            switch options.OnConflict {
+            case CONFLICT_SKIP:
+                item.Status, item.Reason, item.Id = "skipped", "name exists", existing.Id
+                idMap[node.Id] = existing.Id
+                report.add(item)
+                continue
+            case CONFLICT_RENAME:
+                node.Name = renameForConflict(node.Name, exists)
+                item.Name, item.Status = node.Name, "renamed"
+            case CONFLICT_OVERWRITE:
+                item.Id, item.Status = existing.Id, "overwritten"
+            }
+        }
+
+        node.Links, node.Attachments = "", nil
+        node, err = op.seal(node)
+        if err == nil && item.Status == "overwritten" {
+            existing, _ := findByName(node.Name)
+            node.Id, node.Attachments = existing.Id, existing.Attachments
+            err = op.store.Update(node)
+        } else if err == nil {
+            item.Id, err = op.addNode(node)
+        }
+        if err != nil {
+            item.Status, item.Reason = "failed", err.Error()
+        } else {
+            idMap[bundle.Nodes[i].Id] = item.Id
+            imported[len(report.Items)] = i
+        }
+        report.add(item)
+    }
+
+    for n, i := range imported {
+        item, original := &report.Items[n], bundle.Nodes[i]
+        links := []string{}
+        for _, link := range splitList(original.Links) {
+            if idMap[link] != "" {
+                links = append(links, idMap[link])
+            }
+        }
+        attachments, err := saveAttachments(bundle, original, item.Id)
+        if len(links) == 0 && len(attachments) == 0 {
+            continue
+        }
+        node, err2 := op.store.GetById(item.Id)
+        if err == nil {
+            err = err2
+        }
+        if err == nil {
+            node.Links = strings.Join(links, ",")
+            if len(attachments) > 0 {
+                node.Attachments = attachments
+            }
+            err = op.store.Update(node)
+        }
+        if err != nil {
+            item.Reason = err.Error()
+        }
+    }
+
+    op.data = report
+    if !op.isText() {
+        return
+    }
+    switch {
+    case report.Trusted:
+        fmt.Printf("signed by %s (%s)\n", report.Signer, report.Fingerprint)
+    case report.Fingerprint != "":
+        fmt.Printf("signed by an unknown key %s\n", report.Fingerprint)
+    default:
+        fmt.Println("not signed")
+    }
+    if report.Author != "" {
+        fmt.Println("author:", report.Author)
+    }
+    for _, item := range report.Items {
+        line := fmt.Sprintf("%-12s %s -> %s", item.Status, item.File, item.Name)
+        if item.Id != "" {
+            line += "(" + item.Id + ")"
+        }
+        if item.Reason != "" {
+            line += ": " + item.Reason
+        }
+        fmt.Println(line)
+    }
+    for _, conflict := range report.AliasConflicts {
+        fmt.Println("alias conflict", conflict)
+    }
+    fmt.Println(resultDelimiter)
+    fmt.Printf("added: %d, renamed: %d, overwritten: %d, skipped: %d, failed: %d, aliases added: %d\n",
+        report.Added, report.Renamed, report.Overwritten, report.Skipped, report.Failed, report.AliasesAdded)
+}
+
+// BundleKeygen creates the signing key and prints the public key to share.
+func (op *Operator) BundleKeygen(keyFile string) {
+    pub, err := generateBundleKey(keyFile)
+    if err != nil {
+        op.err = err
+        return
+    }
+    publicKey := base64.StdEncoding.EncodeToString(pub)
+    op.data = map[string]interface{}{"KeyFile": keyFile, "PublicKey": publicKey, "Fingerprint": keyFingerprint(pub)}
+    if op.isText() {
+        fmt.Println("signing key written to", keyFile)
+        fmt.Println("public key:", publicKey)
+        fmt.Println("fingerprint:", keyFingerprint(pub))
+        fmt.Println("teammates trust it with \"Bundle\": { \"TrustedKeys\": { \"<your name>\": \"" + publicKey + "\" } } in their config")
+    }
+}
+
+// SyncInit creates the git store, see initGitStore.
+func (op *Operator) SyncInit(config GitConfig, url string) {
+    if op.err != nil {
+        return
+    }
+    if config.Dir == "" {
+        op.err = newCodedError(ERR_USAGE, "set \"Git\": { \"Dir\": \"...\" } in " + configFilePath + " first")
+        return
+    }
+    gitStore, err := initGitStore(config, url, op.store)
+    if err != nil {
+        op.err = err
+        return
+    }
+    nodes := len(gitStore.ListNodes([]string{}))
+    op.data = map[string]interface{}{"Dir": config.Dir, "Url": url, "Nodes": nodes}
+    if op.isText() {
+        fmt.Printf("git store with %d nodes ready in %s\n", nodes, config.Dir)
+    }
+}
+
+// Sync merges the git store with its remote, conflicts open in the editor.
+func (op *Operator) Sync() {
+    if op.err != nil {
+        return
+    }
+    gitStore, ok := op.store.(*GitStore)
+    if !ok {
+        op.err = newCodedError(ERR_USAGE, "sync needs the git store, set \"Git\": { \"Dir\": \"...\" } in " +
+            configFilePath + " and run gaia sync --init [<url>]")
+        return
+    }
+    report, err := gitStore.Sync(op.editor)
+    op.data, op.err = report, err
+    if err != nil || !op.isText() {
+        return
+    }
+    for _, file := range report.Resolved {
+        fmt.Println("resolved", file)
+    }
+    switch {
+    case report.Pulled && report.Pushed:
+        fmt.Println("merged and pushed", gitStore.Remote + "/" + gitStore.Branch)
+    case report.Pulled:
+        fmt.Println("pulled", gitStore.Remote + "/" + gitStore.Branch)
+    case report.Pushed:
+        fmt.Println("pushed to", gitStore.Remote + "/" + gitStore.Branch)
+    default:
+        fmt.Println("already up to date")
+    }
+}
+
+// Fsck checks the data, problems left unfixed are an error.
+func (op *Operator) Fsck(repair bool) {
+    if op.err != nil {
+        return
+    }
+    issues, err := op.store.Fsck(repair)
+    if err != nil {
+        op.err = err
+        return
+    }
+    report := FsckReport{issues, 0}
+    for _, issue := range issues {
+        if !issue.Fixed {
+            report.Unfixed++
+        }
+    }
+    // json and yaml keep the issues, an error would drop them.
+    op.data = report
+    if !op.isText() {
+        return
+    }
+    for _, issue := range issues {
+        fmt.Println(issue)
+    }
+    if len(issues) == 0 {
+        fmt.Println("no problems found")
+    }
+    if unfixed := report.Unfixed; unfixed > 0 {
+        hint := ", run gaia admin fsck --repair"
+        if repair {
+            hint = ", fix them by hand"
+        }
+        op.err = newCodedError(ERR_INVALID, fmt.Sprintf("%d problems left%s", unfixed, hint))
+    }
+}
+
+// MigrateData upgrades the data file, see migrate.go.
+func (op *Operator) MigrateData(path string, check bool) {
+    if op.err != nil {
+        return
+    }
+    report, err := migrateDataFile(path, check)
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.data = report
+    if !op.isText() {
+        return
+    }
+    if len(report.Steps) == 0 {
+        fmt.Printf("%s is at version %d, nothing to do\n", path, report.To)
+        return
+    }
+    for _, step := range report.Steps {
+        fmt.Printf("to version %d: %s\n", step.Version, step.Desc)
+        for _, change := range step.Changes {
+            fmt.Println("   ", change)
+        }
+    }
+    if report.Written {
+        fmt.Printf("migrated %s from version %d to %d, the old file is %s\n", path, report.From, report.To, report.Backup)
+    } else {
+        fmt.Printf("%s would be migrated from version %d to %d\n", path, report.From, report.To)
+    }
+}
+
+// MergeData merges data.json copies, see merge-data.go. Conflicts are an
+// error so the exit status tells git.
+func (op *Operator) MergeData(base, ours, theirs string) {
+    if op.err != nil {
+        return
+    }
+    report, err := mergeDataFiles(base, ours, theirs)
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.data = report
+    if op.isText() {
+        for oldId, newId := range report.Reissued {
+            fmt.Printf("reissued %s -> %s\n", oldId, newId)
+        }
+        for id, name := range report.Renamed {
+            fmt.Printf("renamed %s -> %s\n", id, name)
+        }
+        for _, conflict := range report.Conflicts {
+            fmt.Println("conflict", conflict)
+        }
+        fmt.Printf("merged %d nodes into %s\n", report.Nodes, ours)
+    }
+    if len(report.Conflicts) > 0 {
+        op.err = newCodedError(ERR_CONFLICT, fmt.Sprintf("%d conflicts, fix them with gaia edit", len(report.Conflicts)))
+    }
+}
+
+func (op *Operator) Vars(id string) {
+    node, err := op.getUnlocked(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    vars := parseTemplateVars(node.Content)
+    op.data = vars
+    if !op.isText() {
+        return
+    }
+    if len(vars) == 0 {
+        fmt.Println("No Variables")
+        return
+    }
+    for _, v := range vars {
+        if v.HasDefault {
+            fmt.Printf("%-16s default: %s\n", v.Name, v.Default)
+        } else {
+            fmt.Printf("%-16s (required)\n", v.Name)
+        }
+    }
+}
+
+// Get prints a node, when values is not empty template variables are
+// rendered with them and their defaults. With copyContent the content, or
+// the anchor section, goes to the clipboard instead.
+func (op *Operator) Get(id string, onlyContent bool, copyContent bool, values map[string]string) {
+    id, anchor, lineRange := splitAddress(id)
+    if anchor != "" {
+        anchor = "#" + anchor
+    }
+
+    node, err := op.getUnlocked(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if len(values) > 0 {
+        var missing []string
+        node.Content, missing = renderTemplate(node.Content, values)
+        if op.err = missingVarsError(missing); op.err != nil {
+            return
+        }
+    }
+    if op.err = op.store.Touch(id); op.err != nil {
+        return
+    }
+
+    if anchor != "" || lineRange != "" {
+        node.Content, op.err = addressContent(node.Content, isProse(node), anchor, lineRange)
+        if op.err != nil {
+            return
+        }
+    }
+
+    if copyContent {
+        op.copyToClipboard(id, anchor, node.Content)
+    } else if anchor != "" || lineRange != "" {
+        content := node.Content
+        op.data = map[string]string{"Id": id, "Anchor": anchor, "Lines": lineRange, "Content": content}
+        if op.isText() {
+            fmt.Println(content)
+        }
+    } else if !op.isText() {
+        op.data = node
+    } else {
+        if onlyContent {
+            fmt.Println(node.Content)
+        } else {
+            fmt.Println(resultDelimiter)
+            fmt.Print(node.StringWithoutEmpty())
+            fmt.Println(resultDelimiter)
+        }
+    }
+}
+
+// Toc lists the anchors `gaia get id#anchor` can address, see anchor.go.
+func (op *Operator) Toc(id string) {
+    node, err := op.getUnlocked(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+    sections := parseSections(node.Content, isProse(node))
+    op.data = sections
+    if !op.isText() {
+        return
+    }
+    if len(sections) == 0 {
+        fmt.Println("no anchors in node " + id + ", address lines with " + id + ":<from>-<to>")
+        return
+    }
+    for _, s := range sections {
+        indent := strings.Repeat("  ", strings.Count(s.Path, "/"))
+        fmt.Printf("%-40s %-8s %5s  %s\n", indent + id + "#" + s.Path, s.Kind, fmt.Sprintf("%d-%d", s.Start, s.End), s.Title)
+    }
+}
+
+// reviewCards lists the nodes tagged card, and tag as well when given.
+func (op *Operator) reviewCards(tag string) []Node {
+    cards := []Node{}
+    for _, node := range op.store.ListNodes([]string{}) {
+        if isCard(node) && !node.Secret && (tag == "" || hasTag(node, tag)) {
+            cards = append(cards, node)
+        }
+    }
+    return cards
+}
+
+// Review asks the due cards one by one and reschedules them by the
+// grade given, see cards.go. limit caps the session when above 0.
+func (op *Operator) Review(tag string, limit int, in io.Reader, out io.Writer) {
+    if !op.isText() {
+        op.err = newCodedError(ERR_USAGE, "review is interactive, use --stats for structured output")
+        return
+    }
+
+    due := dueCards(op.reviewCards(tag), op.store.GetCardStates(), time.Now())
+    if limit > 0 && len(due) > limit {
+        due = due[:limit]
+    }
+    if len(due) == 0 {
+        fmt.Fprintln(out, "no cards due, well done")
+        return
+    }
+
+    reader := bufio.NewReader(in)
+    reviewed, correct := 0, 0
+    for i, card := range due {
+        question, answer := cardSides(card.Node)
+        fmt.Fprintln(out, resultDelimiter)
+        fmt.Fprintf(out, "[%d/%d] %s (%s)\n\n%s\n\n", i + 1, len(due), card.Node.Name, card.Node.Id, question)
+        fmt.Fprint(out, "press enter to show the answer ")
+        if _, err := reader.ReadString('\n'); err != nil {
+            break
+        }
+        fmt.Fprintf(out, "\n%s\n\n", answer)
+
+        grade, _ := readGrade(reader, out)
+        if grade == "q" {
+            break
+        }
+        if grade == "s" {
+            continue
+        }
+
+        g := int(grade[0] - '0')
+        state := card.State.schedule(g, time.Now())
+        if op.err = op.store.SetCardState(card.Node.Id, state); op.err != nil {
+            return
+        }
+        reviewed++
+        if g >= 3 {
+            correct++
+        }
+        fmt.Fprintf(out, "next review in %d days\n", state.Interval)
+    }
+    fmt.Fprintln(out, resultDelimiter)
+    fmt.Fprintf(out, "reviewed %d cards, %d correct\n", reviewed, correct)
+}
+
+func (op *Operator) ReviewStats(tag string) {
+    stats := cardStats(op.reviewCards(tag), op.store.GetCardStates(), time.Now())
+    op.data = stats
+    if !op.isText() {
+        return
+    }
+    fmt.Println("Cards:")
+    fmt.Printf("    Total:      %d\n", stats.Cards)
+    fmt.Printf("    New:        %d\n", stats.New)
+    fmt.Printf("    Due today:  %d\n", stats.DueToday)
+    fmt.Printf("    Due 7 days: %d\n", stats.DueWeek)
+    fmt.Printf("    Learning:   %d\n", stats.Learning)
+    fmt.Printf("    Mature:     %d\n", stats.Mature)
+    fmt.Printf("    Reviews:    %d\n", stats.Reviews)
+    fmt.Printf("    Retention:  %.1f%%\n", stats.Retention * 100)
+}
+
+func (op *Operator) Stats() {
+    stats := op.store.GetStats()
+    op.data = stats
+    if !op.isText() {
+        return
+    }
+    fmt.Println("Stats:")
+    fmt.Printf("    CategorySize: %d\n", stats.CategorySize)
+    fmt.Printf("    NodeSize:     %d\n", stats.NodeSize)
+    fmt.Printf("    TagSize:      %d\n", stats.TagSize)
+}
+
+func (op *Operator) FormatData() {
+    if op.err != nil {
+        return
+    }
+    op.err = op.store.FormatData()
+}
+
+func (op *Operator) ReorgAllData() {
+    if op.err != nil {
+        return
+    }
+    op.err = op.store.ReorgAllData()
 }
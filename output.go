@@ -0,0 +1,220 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    TEXT_OUTPUT = "text"
+    JSON_OUTPUT = "json"
+    YAML_OUTPUT = "yaml"
+)
+
+// bump when a field of Result or of a command's Data changes meaning or
+// goes away, adding fields keeps the version.
+const outputSchemaVersion = 1
+
+const (
+    ERR_NOT_FOUND = "not_found"
+    ERR_CONFLICT = "conflict"
+    ERR_INVALID = "invalid_argument"
+    ERR_USAGE = "usage"
+    ERR_NOT_IMPLEMENTED = "not_implemented"
+    ERR_UNAUTHORIZED = "unauthorized"
+    ERR_FORBIDDEN = "forbidden"
+    ERR_UNAVAILABLE = "unavailable"
+    ERR_INTERNAL = "internal"
+)
+
+// Result is what --output json|yaml prints for every command.
+type Result struct {
+    SchemaVersion int
+    Command string
+    Ok bool
+    Data interface{} `json:"Data,omitempty"`
+    Error *ErrorResult `json:"Error,omitempty"`
+}
+
+type ErrorResult struct {
+    Code string
+    Message string
+}
+
+// CodedError carries one of the ERR_* codes for structured output.
+type CodedError struct {
+    Code string
+    Msg string
+}
+
+func (e *CodedError) Error() string {
+    return e.Msg
+}
+
+func newCodedError(code, msg string) error {
+    return &CodedError{code, msg}
+}
+
+func errorCode(err error) string {
+    if codedErr, ok := err.(*CodedError); ok {
+        return codedErr.Code
+    }
+    return ERR_INTERNAL
+}
+
+func checkOutputFormat(format string) error {
+    switch format {
+    case TEXT_OUTPUT, JSON_OUTPUT, YAML_OUTPUT:
+        return nil
+    }
+    return newCodedError(ERR_USAGE, "unknown output format: " + format + ", expect json, yaml or text")
+}
+
+// extractOutputFlag takes --output/-output from anywhere in args, so it can
+// also be given before the command.
+func extractOutputFlag(args []string) ([]string, string) {
+    format := ""
+    rest := []string{}
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        if arg == "--output" || arg == "-output" {
+            if i + 1 < len(args) {
+                format = args[i + 1]
+                i++
+            }
+            continue
+        }
+        if strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "-output=") {
+            format = arg[strings.Index(arg, "=") + 1:]
+            continue
+        }
+        rest = append(rest, arg)
+    }
+    return rest, format
+}
+
+func printResult(format string, command string, data interface{}, err error) {
+    result := Result{SchemaVersion: outputSchemaVersion, Command: command, Ok: err == nil, Data: data}
+    if err != nil {
+        result.Error = &ErrorResult{errorCode(err), err.Error()}
+        result.Data = nil
+    }
+
+    bs, marshalErr := json.MarshalIndent(result, "", "  ")
+    if marshalErr != nil {
+        fmt.Println("error:", marshalErr)
+        os.Exit(-1)
+    }
+    if format == YAML_OUTPUT {
+        var v interface{}
+        json.Unmarshal(bs, &v)
+        fmt.Print(toYaml(v, ""))
+    } else {
+        fmt.Println(string(bs))
+    }
+}
+
+// toYaml writes json decoded values as yaml, keys sorted.
+func toYaml(v interface{}, indent string) string {
+    switch val := v.(type) {
+    case map[string]interface{}:
+        if len(val) == 0 {
+            return indent + "{}\n"
+        }
+        keys := []string{}
+        for k := range val {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+
+        res := ""
+        for _, k := range keys {
+            res += yamlEntry(indent, yamlString(k) + ":", val[k])
+        }
+        return res
+    case []interface{}:
+        if len(val) == 0 {
+            return indent + "[]\n"
+        }
+        res := ""
+        for _, item := range val {
+            res += yamlEntry(indent, "-", item)
+        }
+        return res
+    default:
+        return indent + yamlScalar(val, indent) + "\n"
+    }
+}
+
+func yamlEntry(indent string, label string, v interface{}) string {
+    switch val := v.(type) {
+    case map[string]interface{}:
+        if len(val) > 0 && label == "-" {
+            return indent + "- " + strings.TrimPrefix(toYaml(val, indent + "  "), indent + "  ")
+        }
+        if len(val) > 0 {
+            return indent + label + "\n" + toYaml(val, indent + "  ")
+        }
+    case []interface{}:
+        if len(val) > 0 {
+            return indent + label + "\n" + toYaml(val, indent + "  ")
+        }
+    }
+    return indent + label + " " + strings.TrimPrefix(toYaml(v, indent + "  "), indent + "  ")
+}
+
+func yamlScalar(v interface{}, indent string) string {
+    switch val := v.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return strconv.FormatBool(val)
+    case float64:
+        return strconv.FormatFloat(val, 'f', -1, 64)
+    case string:
+        if strings.Contains(val, "\n") {
+            lines := strings.Split(strings.TrimSuffix(val, "\n"), "\n")
+            chomp := "|-"
+            if strings.HasSuffix(val, "\n") {
+                chomp = "|"
+            }
+            // the indicator counts from the parent, toYaml is called two
+            // deeper than it, the document root sits at -1.
+            if strings.HasPrefix(lines[0], " ") {
+                parent := len(indent) - 2
+                if parent < 0 {
+                    parent = -1
+                }
+                chomp += strconv.Itoa(len(indent) + 2 - parent)
+            }
+            return chomp + "\n" + indent + "  " + strings.Join(lines, "\n" + indent + "  ")
+        }
+        return yamlString(val)
+    case map[string]interface{}, []interface{}:
+        return strings.TrimSpace(toYaml(val, indent))
+    }
+    return yamlString(fmt.Sprint(v))
+}
+
+func yamlString(s string) string {
+    plain := s != "" && strings.IndexAny(s, ":#{}[],&*!|>'\"%@`\t") < 0 &&
+        strings.TrimSpace(s) == s && !strings.HasPrefix(s, "-") && !strings.HasPrefix(s, "?")
+    if plain {
+        switch strings.ToLower(s) {
+        case "true", "false", "null", "yes", "no", "on", "off", "~":
+            plain = false
+        }
+        if _, err := strconv.ParseFloat(s, 64); err == nil {
+            plain = false
+        }
+    }
+    if plain {
+        return s
+    }
+    bs, _ := json.Marshal(s)
+    return string(bs)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+)
+
+// a small PDF 1.4 writer: A4 pages, the standard 14 fonts in WinAnsi
+// encoding and colored text, nothing to install.
+
+const (
+    pdfPageWidth = 595.0
+    pdfPageHeight = 842.0
+)
+
+// font resource names, see pdfDoc.bytes.
+const (
+    PDF_SANS = "F1"
+    PDF_SANS_BOLD = "F2"
+    PDF_MONO = "F3"
+    PDF_MONO_BOLD = "F4"
+)
+
+var pdfFontNames = [][2]string{
+    {PDF_SANS, "Helvetica"},
+    {PDF_SANS_BOLD, "Helvetica-Bold"},
+    {PDF_MONO, "Courier"},
+    {PDF_MONO_BOLD, "Courier-Bold"},
+}
+
+type pdfColor [3]float64
+
+var pdfBlack = pdfColor{0, 0, 0}
+
+type pdfDoc struct {
+    pages []*bytes.Buffer
+    title string
+}
+
+func newPdfDoc(title string) *pdfDoc {
+    return &pdfDoc{title: title}
+}
+
+func (doc *pdfDoc) addPage() int {
+    doc.pages = append(doc.pages, &bytes.Buffer{})
+    return len(doc.pages) - 1
+}
+
+// text draws s with its baseline at x, y, measured from the bottom left.
+func (doc *pdfDoc) text(page int, x, y float64, font string, size float64, color pdfColor, s string) {
+    fmt.Fprintf(doc.pages[page], "BT %.3f %.3f %.3f rg /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+        color[0], color[1], color[2], font, size, x, y, pdfEscape(s))
+}
+
+func (doc *pdfDoc) line(page int, x1, y1, x2, y2 float64, width float64) {
+    fmt.Fprintf(doc.pages[page], "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// pdfTextWidth estimates the width of s, exact for Courier and close
+// enough for Helvetica to wrap lines.
+func pdfTextWidth(font string, size float64, s string) float64 {
+    n := float64(len([]rune(s)))
+    if font == PDF_MONO || font == PDF_MONO_BOLD {
+        return n * 0.6 * size
+    }
+    return n * 0.55 * size
+}
+
+// pdfEscape encodes s as WinAnsi, characters outside of it become '?'.
+func pdfEscape(s string) string {
+    var buf bytes.Buffer
+    for _, r := range s {
+        switch {
+        case r == '\\' || r == '(' || r == ')':
+            buf.WriteByte('\\')
+            buf.WriteRune(r)
+        case r == '\t':
+            buf.WriteString("    ")
+        case r >= 32 && r < 127:
+            buf.WriteRune(r)
+        case r >= 160 && r <= 255:
+            fmt.Fprintf(&buf, "\\%03o", r)
+        default:
+            buf.WriteByte('?')
+        }
+    }
+    return buf.String()
+}
+
+func (doc *pdfDoc) bytes() []byte {
+    var out bytes.Buffer
+    offsets := []int{}
+    writeObj := func(body string) {
+        offsets = append(offsets, out.Len())
+        fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+    }
+
+    out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+    // 1 catalog, 2 pages, 3 info, 4.. fonts, then a page and its contents
+    // per page.
+    fontStart := 4
+    pageStart := fontStart + len(pdfFontNames)
+    kids := []string{}
+    for i := range doc.pages {
+        kids = append(kids, fmt.Sprintf("%d 0 R", pageStart + 2 * i))
+    }
+
+    writeObj("<< /Type /Catalog /Pages 2 0 R >>")
+    writeObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+    writeObj(fmt.Sprintf("<< /Title (%s) /Producer (gaia) >>", pdfEscape(doc.title)))
+
+    fontRefs := ""
+    for i, font := range pdfFontNames {
+        writeObj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font[1]))
+        fontRefs += fmt.Sprintf("/%s %d 0 R ", font[0], fontStart + i)
+    }
+
+    for i, page := range doc.pages {
+        writeObj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+            pdfPageWidth, pdfPageHeight, fontRefs, pageStart + 2 * i + 1))
+        writeObj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+    }
+
+    xrefOffset := out.Len()
+    fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets) + 1)
+    for _, offset := range offsets {
+        fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+    }
+    fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets) + 1, xrefOffset)
+    return out.Bytes()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// A project packs several files into one node content:
+//
+//   /***
+//   name := "demo"          <- optional meta block, must come first
+//   */
+//   console.log("main")     <- the main file, named by the node's ExecFile
+//   /**# lib/util.js #*/     <- every separator starts a new file
+//   module.exports = {}
+//
+// Lines starting with #! are dropped, so a node can carry a shebang.
+// A content line that would read as one of the markers above is escaped
+// with a backslash in front of it: `\*/`, `\/**# x #*/`, `\#!/bin/sh`.
+// Unpacking removes exactly one backslash, so already escaped lines
+// survive another round trip.
+
+const maxProjectFiles = 64
+const maxProjectSize = 1 << 20
+
+var projectSkipDirs = []string{".git", ".svn", ".hg", "node_modules", "target", ".idea", ".bsp"}
+
+func isFileSeparator(lineTrimed string) bool {
+    return strings.HasPrefix(lineTrimed, "/**#") && strings.HasSuffix(lineTrimed, "#*/") && len(lineTrimed) >= 7
+}
+
+func isProjectMarker(lineTrimed string) bool {
+    return lineTrimed == "/***" || lineTrimed == "*/" || isFileSeparator(lineTrimed) ||
+        strings.HasPrefix(lineTrimed, "#!")
+}
+
+func escapeProjectLine(line string) string {
+    lineTrimed := strings.TrimSpace(line)
+    if !isProjectMarker(strings.TrimLeft(lineTrimed, "\\")) {
+        return line
+    }
+    index := strings.Index(line, lineTrimed)
+    return line[:index] + "\\" + line[index:]
+}
+
+func unescapeProjectLine(line string) string {
+    lineTrimed := strings.TrimSpace(line)
+    if !strings.HasPrefix(lineTrimed, "\\") || !isProjectMarker(strings.TrimLeft(lineTrimed, "\\")) {
+        return line
+    }
+    index := strings.Index(line, lineTrimed)
+    return line[:index] + line[index + 1:]
+}
+
+// unpackProject splits packed content into files, the meta block goes to
+// MetaFile. The returned main file is mainFile, or the first separated file
+// when the content before any separator is empty.
+func unpackProject(mainFile string, r io.Reader) (map[string]string, string) {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64 * 1024), maxProjectSize)
+    currentFileName := mainFile
+    resultMainFile := mainFile
+    filesMap := make(map[string]string)
+    fileContent := ""
+    for scanner.Scan() {
+        line := scanner.Text()
+        lineTrimed := strings.TrimSpace(line)
+
+        if strings.HasPrefix(lineTrimed, "#!") {
+            continue
+        }
+
+        if lineTrimed == "/***" {
+            currentFileName = MetaFile
+            fileContent = ""
+        } else if lineTrimed == "*/" && currentFileName == MetaFile {
+            filesMap[currentFileName] = fileContent
+            currentFileName = mainFile
+            fileContent = ""
+        } else if isFileSeparator(lineTrimed) {
+            filesMap[currentFileName] = fileContent
+            len := len([]rune(lineTrimed))
+            currentFileName = strings.TrimSpace(string([]rune(lineTrimed)[4 : len-3]))
+            fileContent = ""
+
+            // setup MainFile
+            mainFileContent := filesMap[mainFile]
+            if strings.TrimSpace(mainFileContent) == "" {
+                resultMainFile = currentFileName
+            }
+        } else {
+            fileContent += unescapeProjectLine(line) + "\n"
+        }
+    }
+
+    filesMap[currentFileName] = fileContent
+    return filesMap, resultMainFile
+}
+
+// packProject is the inverse of unpackProject.
+func packProject(mainFile string, filesMap map[string]string) string {
+    writeLines := func(content string) string {
+        res := ""
+        content = strings.TrimSuffix(content, "\n")
+        for _, line := range strings.Split(content, "\n") {
+            res += escapeProjectLine(line) + "\n"
+        }
+        return res
+    }
+
+    res := ""
+    if meta, exist := filesMap[MetaFile]; exist && strings.TrimSpace(meta) != "" {
+        res += "/***\n" + writeLines(meta) + "*/\n"
+    }
+    if content, exist := filesMap[mainFile]; exist {
+        res += writeLines(content)
+    }
+
+    names := []string{}
+    for name := range filesMap {
+        if name != MetaFile && name != mainFile {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        res += "/**# " + name + " #*/\n" + writeLines(filesMap[name])
+    }
+    return strings.TrimSuffix(res, "\n")
+}
+
+func checkProjectFileName(name string) error {
+    clean := filepath.Clean(name)
+    if name == "" || filepath.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+        return errors.New("invalid project file name: " + name)
+    }
+    return nil
+}
+
+// writeProject writes unpacked files below dir.
+func writeProject(dir string, filesMap map[string]string) error {
+    for name, content := range filesMap {
+        if strings.TrimSpace(content) == "" {
+            continue
+        }
+        if err := checkProjectFileName(name); err != nil {
+            return err
+        }
+
+        file := filepath.Join(dir, name)
+        if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+            return err
+        }
+        if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// readProject reads the text files below dir, keyed by slash separated
+// relative path. Version control and build output dirs are skipped.
+func readProject(dir string) (map[string]string, error) {
+    filesMap := make(map[string]string)
+    totalSize := 0
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.IsDir() {
+            if path != dir && ArrContains(projectSkipDirs, info.Name()) {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if !info.Mode().IsRegular() {
+            return nil
+        }
+
+        rel, err := filepath.Rel(dir, path)
+        if err != nil {
+            return err
+        }
+        bs, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        if strings.IndexByte(string(bs), 0) >= 0 {
+            return errors.New("binary file can not be packed: " + rel)
+        }
+
+        totalSize += len(bs)
+        if len(filesMap) >= maxProjectFiles || totalSize > maxProjectSize {
+            return errors.New("project too large, max 64 files and 1MB")
+        }
+        filesMap[filepath.ToSlash(rel)] = string(bs)
+        return nil
+    })
+    return filesMap, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+const sandboxInitCommand = "__sandbox-init"
+const sandboxSpecEnv = "GAIA_SANDBOX_SPEC"
+
+// system dirs shown read-only inside the sandbox, missing ones are skipped.
+var sandboxSystemPaths = []string{
+    "/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32",
+    "/etc", "/opt", "/nix", "/snap",
+}
+
+type sandboxMode int
+
+const (
+    BWRAP_SANDBOX sandboxMode = iota
+    NAMESPACE_SANDBOX
+)
+
+// Sandbox runs a command with only ProjectDir writable, the toolchain
+// read-only and $HOME, ~/.gaia and the rest of the host hidden.
+type Sandbox struct {
+    Mode sandboxMode
+    ProjectDir string
+    ReadOnlyPaths []string
+    NoNetwork bool
+}
+
+// newSandbox picks bubblewrap when installed and falls back to our own
+// namespaces, the error tells why neither works on this host.
+func newSandbox(projectDir string, extraPaths []string, noNetwork bool) (*Sandbox, error) {
+    sb := &Sandbox{ProjectDir: projectDir, NoNetwork: noNetwork}
+    if _, err := exec.LookPath("bwrap"); err == nil {
+        sb.Mode = BWRAP_SANDBOX
+    } else if err := nativeSandboxSupported(); err == nil {
+        sb.Mode = NAMESPACE_SANDBOX
+    } else {
+        return nil, errors.New("sandbox is not available on this host: " + err.Error() +
+            "; install bubblewrap (bwrap) or enable unprivileged user namespaces")
+    }
+
+    paths := append([]string{}, sandboxSystemPaths...)
+    paths = append(paths, extraPaths...)
+    for _, p := range paths {
+        sb.addReadOnlyPath(p)
+    }
+    return sb, nil
+}
+
+// addReadOnlyPath skips $HOME and paths inside or around ~/.gaia, the
+// data and exec cache must not show up in the sandbox.
+func (sb *Sandbox) addReadOnlyPath(p string) bool {
+    p = filepath.Clean(p)
+    if !filepath.IsAbs(p) || p == "/" || isUnderDir(p, gaiaDir) || isUnderDir(filepath.Clean(gaiaDir), p) {
+        return false
+    }
+    if home, err := os.UserHomeDir(); err == nil && p == filepath.Clean(home) {
+        return false
+    }
+    if _, err := os.Stat(p); err != nil {
+        return false
+    }
+    for _, existing := range sb.ReadOnlyPaths {
+        if isUnderDir(p, existing) {
+            return true
+        }
+    }
+    sb.ReadOnlyPaths = append(sb.ReadOnlyPaths, p)
+    return true
+}
+
+// addToolchain shows the install prefix of a command read-only, e.g.
+// ~/.nvm/versions/node/v18/bin/node adds ~/.nvm/versions/node/v18. When
+// the prefix is refused, like $HOME for ~/bin/tool, only the binary's own
+// dir is shown.
+func (sb *Sandbox) addToolchain(command string) {
+    path, err := exec.LookPath(command)
+    if err != nil {
+        return
+    }
+    if resolved, err := filepath.EvalSymlinks(path); err == nil {
+        path = resolved
+    }
+    if !sb.addReadOnlyPath(filepath.Dir(filepath.Dir(path))) {
+        sb.addReadOnlyPath(filepath.Dir(path))
+    }
+}
+
+func (sb *Sandbox) wrap(parts []string) []string {
+    if sb.Mode == BWRAP_SANDBOX {
+        return append(sb.bwrapArgs(), parts...)
+    }
+
+    self, err := os.Executable()
+    if err != nil {
+        self = os.Args[0]
+    }
+    return append([]string{self, sandboxInitCommand, "--"}, parts...)
+}
+
+func (sb *Sandbox) bwrapArgs() []string {
+    args := []string{"bwrap", "--unshare-user", "--unshare-pid", "--unshare-ipc", "--unshare-uts", "--die-with-parent"}
+    if sb.NoNetwork {
+        args = append(args, "--unshare-net")
+    }
+    for _, p := range sb.ReadOnlyPaths {
+        if target, err := os.Readlink(p); err == nil {
+            args = append(args, "--symlink", target, p)
+        } else {
+            args = append(args, "--ro-bind", p, p)
+        }
+    }
+    args = append(args,
+        "--proc", "/proc",
+        "--dev", "/dev",
+        "--tmpfs", "/tmp",
+        "--bind", sb.ProjectDir, sb.ProjectDir,
+        "--chdir", sb.ProjectDir,
+        "--")
+    return args
+}
+
+// env points HOME into the project, so package manager caches end up there.
+func (sb *Sandbox) env() []string {
+    env := []string{}
+    for _, kv := range os.Environ() {
+        if strings.HasPrefix(kv, "HOME=") || strings.HasPrefix(kv, "TMPDIR=") || strings.HasPrefix(kv, sandboxSpecEnv + "=") {
+            continue
+        }
+        env = append(env, kv)
+    }
+    env = append(env, "HOME=" + sb.ProjectDir, "TMPDIR=/tmp")
+    if sb.Mode == NAMESPACE_SANDBOX {
+        spec, _ := json.Marshal(sb)
+        env = append(env, sandboxSpecEnv + "=" + string(spec))
+    }
+    return env
+}
+
+func isUnderDir(p, dir string) bool {
+    dir = strings.TrimSuffix(filepath.Clean(dir), "/")
+    return p == dir || strings.HasPrefix(p, dir + "/")
+}
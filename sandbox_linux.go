@@ -0,0 +1,191 @@
+// +build linux
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "syscall"
+)
+
+// statfs flags that are locked on mounts inherited by a user namespace,
+// a read-only remount has to keep them or the kernel refuses it.
+var lockedMountFlags = map[int64]uintptr{
+    2: syscall.MS_NOSUID,
+    4: syscall.MS_NODEV,
+    8: syscall.MS_NOEXEC,
+    1024: syscall.MS_NOATIME,
+    2048: syscall.MS_NODIRATIME,
+    4096: syscall.MS_RELATIME,
+}
+
+func nativeSandboxSupported() error {
+    for _, ns := range []string{"user", "mnt", "pid"} {
+        if err := checkNamespaceSupport(ns); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func addSandboxNamespaces(attr *syscall.SysProcAttr) {
+    // root inside the namespace keeps the capabilities needed for mounting
+    // across exec, files still get created as the calling user.
+    attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+    attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+    attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+    attr.GidMappingsEnableSetgroups = false
+}
+
+// sandboxInit runs as pid 1 of the fresh namespaces: it builds a new root
+// from the spec in the environment, then replaces itself with the command.
+func sandboxInit(args []string) {
+    sb := Sandbox{}
+    err := json.Unmarshal([]byte(os.Getenv(sandboxSpecEnv)), &sb)
+    if err == nil {
+        err = sb.setupRoot()
+    }
+    if err == nil {
+        err = os.Chdir(sb.ProjectDir)
+    }
+    if err != nil {
+        fmt.Println("sandbox error:", err)
+        os.Exit(-1)
+    }
+
+    if len(args) > 0 && args[0] == "--" {
+        args = args[1:]
+    }
+    if len(args) == 0 {
+        fmt.Println("sandbox error: no command")
+        os.Exit(-1)
+    }
+    path, err := exec.LookPath(args[0])
+    if err != nil {
+        fmt.Println("sandbox error:", err)
+        os.Exit(-1)
+    }
+
+    os.Unsetenv(sandboxSpecEnv)
+    err = syscall.Exec(path, args, os.Environ())
+    fmt.Println("sandbox error:", err)
+    os.Exit(-1)
+}
+
+func (sb *Sandbox) setupRoot() error {
+    if err := syscall.Mount("", "/", "", syscall.MS_REC | syscall.MS_PRIVATE, ""); err != nil {
+        return fmt.Errorf("make mounts private: %v", err)
+    }
+    if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=0755"); err != nil {
+        return fmt.Errorf("mount base tmpfs: %v", err)
+    }
+    for _, dir := range []string{"/tmp/newroot", "/tmp/oldroot"} {
+        if err := os.Mkdir(dir, 0755); err != nil {
+            return err
+        }
+    }
+    if err := syscall.PivotRoot("/tmp", "/tmp/oldroot"); err != nil {
+        return fmt.Errorf("pivot to base: %v", err)
+    }
+    os.Chdir("/")
+
+    newRoot := "/newroot"
+    if err := syscall.Mount(newRoot, newRoot, "", syscall.MS_BIND, ""); err != nil {
+        return err
+    }
+    for _, dir := range []string{"/tmp", "/proc"} {
+        if err := os.MkdirAll(newRoot + dir, 0755); err != nil {
+            return err
+        }
+    }
+    // /tmp first, the project dir usually lives below it.
+    if err := syscall.Mount("tmpfs", newRoot + "/tmp", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=1777"); err != nil {
+        return fmt.Errorf("mount /tmp: %v", err)
+    }
+    for _, p := range sb.ReadOnlyPaths {
+        if err := bindInto(newRoot, p, true); err != nil {
+            return err
+        }
+    }
+    if err := bindInto(newRoot, sb.ProjectDir, false); err != nil {
+        return err
+    }
+    if err := bindInto(newRoot, "/dev", false); err != nil {
+        return err
+    }
+    if err := syscall.Mount("proc", newRoot + "/proc", "proc", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, ""); err != nil {
+        return fmt.Errorf("mount /proc: %v", err)
+    }
+
+    // pivot_root(".", ".") stacks the old root under the new one, detaching
+    // it leaves only what was bound above.
+    if err := os.Chdir(newRoot); err != nil {
+        return err
+    }
+    if err := syscall.PivotRoot(".", "."); err != nil {
+        return fmt.Errorf("pivot to new root: %v", err)
+    }
+    if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+        return fmt.Errorf("detach old root: %v", err)
+    }
+    return os.Chdir("/")
+}
+
+// bindInto binds /oldroot<p> to <newRoot><p>, symlinks are recreated as is.
+func bindInto(newRoot, p string, readOnly bool) error {
+    src := "/oldroot" + p
+    dst := newRoot + p
+
+    info, err := os.Lstat(src)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+        return err
+    }
+    if info.Mode() & os.ModeSymlink != 0 {
+        target, err := os.Readlink(src)
+        if err != nil {
+            return err
+        }
+        return os.Symlink(target, dst)
+    }
+
+    if info.IsDir() {
+        err = os.Mkdir(dst, 0755)
+    } else {
+        var f *os.File
+        f, err = os.Create(dst)
+        if f != nil {
+            f.Close()
+        }
+    }
+    if err != nil {
+        return err
+    }
+
+    if err := syscall.Mount(src, dst, "", syscall.MS_BIND | syscall.MS_REC, ""); err != nil {
+        return fmt.Errorf("bind %s: %v", p, err)
+    }
+    if !readOnly {
+        return nil
+    }
+
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(dst, &st); err != nil {
+        return err
+    }
+    flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+    for stFlag, msFlag := range lockedMountFlags {
+        if int64(st.Flags) & stFlag != 0 {
+            flags |= msFlag
+        }
+    }
+    if err := syscall.Mount("", dst, "", flags, ""); err != nil {
+        return fmt.Errorf("remount %s read-only: %v", p, err)
+    }
+    return nil
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+)
+
+func nativeSandboxSupported() error {
+    return errors.New("namespaces are only available on linux")
+}
+
+func sandboxInit(args []string) {
+    fmt.Println("sandbox error:", nativeSandboxSupported())
+    os.Exit(-1)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "bufio"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+    "golang.org/x/crypto/scrypt"
+)
+
+// The content of a secret node is sealed at rest as one line
+//   gaia-secret:v1:scrypt:<N>:<r>:<p>:<salt>:<nonce>:<ciphertext>
+// with AES-256-GCM under a key scrypt derives from the passphrase. The
+// passphrase comes from $GAIA_PASSPHRASE, the file of Secret.PassphraseFile
+// in config, or is asked for on the terminal. Name, tags and desc stay
+// readable, search and export skip secret nodes unless unlocked.
+const (
+    secretPrefix = "gaia-secret:v1:"
+    secretKdf = "scrypt"
+    secretPassphraseEnv = "GAIA_PASSPHRASE"
+    scryptN = 1 << 15
+    scryptR = 8
+    scryptP = 1
+    secretKeyLen = 32
+    secretSaltLen = 16
+    secretNonceLen = 12 // of AES-GCM
+)
+
+func isSealed(content string) bool {
+    return strings.HasPrefix(content, secretPrefix)
+}
+
+// sealSecret encrypts content with a fresh salt and nonce.
+func sealSecret(content string, passphrase string) (string, error) {
+    salt := make([]byte, secretSaltLen)
+    if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+        return "", err
+    }
+    aead, err := secretAead(passphrase, salt)
+    if err != nil {
+        return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return "", err
+    }
+    sealed := aead.Seal(nil, nonce, []byte(content), []byte(secretPrefix))
+
+    enc := base64.StdEncoding.EncodeToString
+    return secretPrefix + strings.Join([]string{secretKdf, strconv.Itoa(scryptN), strconv.Itoa(scryptR), strconv.Itoa(scryptP),
+        enc(salt), enc(nonce), enc(sealed)}, ":"), nil
+}
+
+// sealedParts splits what sealSecret made. Only the scrypt parameters
+// sealSecret writes are taken, others would let a crafted node make open
+// allocate gigabytes.
+func sealedParts(sealed string) (salt, nonce, data []byte, err error) {
+    parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(sealed), secretPrefix), ":")
+    malformed := newCodedError(ERR_INVALID, "malformed secret content")
+    if !isSealed(sealed) || len(parts) != 7 || parts[0] != secretKdf {
+        return nil, nil, nil, malformed
+    }
+    if parts[1] != strconv.Itoa(scryptN) || parts[2] != strconv.Itoa(scryptR) || parts[3] != strconv.Itoa(scryptP) {
+        return nil, nil, nil, newCodedError(ERR_INVALID, fmt.Sprintf("unsupported secret parameters, expect scrypt N=%d r=%d p=%d", scryptN, scryptR, scryptP))
+    }
+    dec := base64.StdEncoding.DecodeString
+    salt, err1 := dec(parts[4])
+    nonce, err2 := dec(parts[5])
+    data, err3 := dec(parts[6])
+    if err1 != nil || err2 != nil || err3 != nil || len(salt) != secretSaltLen || len(nonce) != secretNonceLen {
+        return nil, nil, nil, malformed
+    }
+    return salt, nonce, data, nil
+}
+
+// openSecret decrypts what sealSecret made.
+func openSecret(sealed string, passphrase string) (string, error) {
+    salt, nonce, data, err := sealedParts(sealed)
+    if err != nil {
+        return "", err
+    }
+    aead, err := secretAead(passphrase, salt)
+    if err != nil {
+        return "", err
+    }
+    content, err := aead.Open(nil, nonce, data, []byte(secretPrefix))
+    if err != nil {
+        return "", newCodedError(ERR_UNAUTHORIZED, "wrong passphrase")
+    }
+    return string(content), nil
+}
+
+// secretKey derives the AES key with the parameters sealSecret writes.
+func secretKey(passphrase string, salt []byte) ([]byte, error) {
+    return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, secretKeyLen)
+}
+
+func secretAead(passphrase string, salt []byte) (cipher.AEAD, error) {
+    key, err := secretKey(passphrase, salt)
+    if err != nil {
+        return nil, err
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// readPassphrase asks on the terminal without echo, twice when confirm
+// is set. Without a terminal the line is read as is.
+func readPassphrase(prompt string, confirm bool) (string, error) {
+    read := func(prompt string) (string, error) {
+        fmt.Fprint(os.Stderr, prompt)
+        echoOff := exec.Command("stty", "-echo")
+        echoOff.Stdin = os.Stdin
+        if echoOff.Run() == nil {
+            defer func() {
+                echoOn := exec.Command("stty", "echo")
+                echoOn.Stdin = os.Stdin
+                echoOn.Run()
+                fmt.Fprintln(os.Stderr)
+            }()
+        }
+        line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+        if err != nil && line == "" {
+            return "", newCodedError(ERR_USAGE, "no passphrase, set $" + secretPassphraseEnv + " or Secret.PassphraseFile in " + configFilePath)
+        }
+        return strings.TrimRight(line, "\r\n"), nil
+    }
+
+    passphrase, err := read(prompt)
+    if err != nil {
+        return "", err
+    }
+    if passphrase == "" {
+        return "", newCodedError(ERR_INVALID, "passphrase is empty")
+    }
+    if confirm {
+        again, err := read("repeat passphrase: ")
+        if err != nil {
+            return "", err
+        }
+        if again != passphrase {
+            return "", newCodedError(ERR_INVALID, "passphrases do not match")
+        }
+    }
+    return passphrase, nil
+}
+
+// passphraseFromFile reads Secret.PassphraseFile, the first line counts.
+func passphraseFromFile(path string) (string, error) {
+    bs, err := ioutil.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    passphrase := strings.TrimRight(strings.SplitN(string(bs), "\n", 2)[0], "\r")
+    if passphrase == "" {
+        return "", newCodedError(ERR_INVALID, "passphrase file is empty: " + path)
+    }
+    return passphrase, nil
+}
+
+// checkSecretWrite keeps plain content, and sealed content openSecret
+// would refuse, out of secret nodes written by callers that can not seal,
+// like the http api.
+func checkSecretWrite(node Node) error {
+    if !node.Secret {
+        return nil
+    }
+    if !isSealed(node.Content) {
+        return newCodedError(ERR_FORBIDDEN, "secret nodes can only be written by gaia add --secret and gaia edit")
+    }
+    _, _, _, err := sealedParts(node.Content)
+    return err
+}
+
+func withoutSecrets(nodes []Node) []Node {
+    res := []Node{}
+    for _, node := range nodes {
+        if !node.Secret {
+            res = append(res, node)
+        }
+    }
+    return res
+}
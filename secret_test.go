@@ -0,0 +1,69 @@
+package main
+
+import (
+    "encoding/hex"
+    "strings"
+    "testing"
+    "golang.org/x/crypto/scrypt"
+)
+
+// a vector of RFC 7914 section 12, and one computed with python's
+// hashlib.scrypt for the parameters sealSecret writes, a change of them
+// would lock out every sealed node.
+func TestSecretKey(t *testing.T) {
+    key, err := scrypt.Key([]byte("pleaseletmein"), []byte("SodiumChloride"), 16384, 8, 1, 64)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := hex.EncodeToString(key), "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"; got != want {
+        t.Errorf("rfc 7914 vector: got %s, want %s", got, want)
+    }
+
+    key, err = secretKey("correct horse", []byte("0123456789abcdef"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := hex.EncodeToString(key), "a6e1c5f30b25e0fe6d47724036c0a579988e41eb3ed39298d8fa076d41293064"; got != want {
+        t.Errorf("secret key: got %s, want %s", got, want)
+    }
+}
+
+func TestSealOpenSecret(t *testing.T) {
+    sealed, err := sealSecret("token=abc", "pw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if content, err := openSecret(sealed, "pw"); err != nil || content != "token=abc" {
+        t.Errorf("open: got %q, %v", content, err)
+    }
+    if _, err := openSecret(sealed, "wrong"); errorCode(err) != ERR_UNAUTHORIZED {
+        t.Errorf("wrong passphrase: got %v", err)
+    }
+    if err := checkSecretWrite(Node{Secret: true, Content: sealed}); err != nil {
+        t.Errorf("check sealed: %v", err)
+    }
+}
+
+// other scrypt parameters are refused before any key is derived.
+func TestOpenSecretParams(t *testing.T) {
+    sealed, err := sealSecret("x", "pw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    params := secretKdf + ":32768:8:1:"
+    for _, bad := range []string{"1048576:8:1:", "32768:1048576:1:", "32768:8:1048576:", "16384:8:1:"} {
+        crafted := strings.Replace(sealed, params, secretKdf + ":" + bad, 1)
+        if _, err := openSecret(crafted, "pw"); errorCode(err) != ERR_INVALID {
+            t.Errorf("%s: got %v", bad, err)
+        }
+        if err := checkSecretWrite(Node{Secret: true, Content: crafted}); errorCode(err) != ERR_INVALID {
+            t.Errorf("check %s: got %v", bad, err)
+        }
+    }
+    if err := checkSecretWrite(Node{Secret: true, Content: secretPrefix + "garbage"}); errorCode(err) != ERR_INVALID {
+        t.Errorf("check garbage: got %v", err)
+    }
+    if err := checkSecretWrite(Node{Secret: true, Content: "plain"}); errorCode(err) != ERR_FORBIDDEN {
+        t.Errorf("check plain: got %v", err)
+    }
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    defaultServeAddr = "127.0.0.1:8080"
+    serveTokenEnv = "GAIA_TOKEN"
+)
+
+const maxRequestBodySize = 4 << 20
+
+// slow clients can not hold connections, the run route lifts the write
+// timeout for its event stream, see runNode.
+const (
+    serveReadHeaderTimeout = 10 * time.Second
+    serveReadTimeout = 30 * time.Second
+    serveWriteTimeout = 60 * time.Second
+    serveIdleTimeout = 2 * time.Minute
+)
+
+// ServeOptions configures `gaia serve`, flags override the Serve section
+// of the config.
+type ServeOptions struct {
+    Addr string
+    Token string // clients send "Authorization: Bearer <token>"
+    CorsOrigins []string // "*" allows any origin
+    ReadOnly bool
+    UI bool // serve the web ui, see ui.go
+}
+
+// apiServer exposes a Store over http:
+//   GET    /nodes
+//   GET    /nodes/{id}
+//   POST   /nodes
+//   PATCH  /nodes/{id}
+//   DELETE /nodes/{id}
+//   GET    /search?q=keywords&category=c
+//   GET    /categories
+//   GET    /aliases
+//   GET    /stats
+// Bodies are Node json, errors are ErrorResult json.
+type apiServer struct {
+    store Store
+    add func(Node) (string, error) // Operator.addNode, which sets Author
+    options ServeOptions
+    mu sync.Mutex // stores are not safe for concurrent use
+}
+
+func newApiServer(op *Operator, options ServeOptions) *apiServer {
+    return &apiServer{store: op.store, add: op.addNode, options: options}
+}
+
+func httpStatus(err error) int {
+    switch errorCode(err) {
+    case ERR_NOT_FOUND:
+        return http.StatusNotFound
+    case ERR_CONFLICT:
+        return http.StatusConflict
+    case ERR_INVALID, ERR_USAGE:
+        return http.StatusBadRequest
+    case ERR_UNAUTHORIZED:
+        return http.StatusUnauthorized
+    case ERR_FORBIDDEN:
+        return http.StatusForbidden
+    case ERR_NOT_IMPLEMENTED:
+        return http.StatusNotImplemented
+    case ERR_UNAVAILABLE:
+        return http.StatusServiceUnavailable
+    }
+    return http.StatusInternalServerError
+}
+
+func writeJson(w http.ResponseWriter, status int, data interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+    writeJson(w, httpStatus(err), ErrorResult{errorCode(err), err.Error()})
+}
+
+func (server *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if err := server.checkOrigin(r); err != nil {
+        writeError(w, err)
+        return
+    }
+    if server.setCorsHeaders(w, r) && r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    path := strings.Trim(r.URL.Path, "/")
+    parts := strings.Split(path, "/")
+    if server.options.UI && serveUiFile(w, r, path) {
+        return
+    }
+    if err := server.authorize(r); err != nil {
+        writeError(w, err)
+        return
+    }
+    if server.options.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+        writeError(w, newCodedError(ERR_FORBIDDEN, "server is read-only"))
+        return
+    }
+    // runs take long, they lock the store only to read the node.
+    if len(parts) == 3 && parts[0] == "nodes" && parts[2] == "run" && r.Method == http.MethodPost {
+        server.runNode(w, r, parts[1])
+        return
+    }
+
+    server.mu.Lock()
+    defer server.mu.Unlock()
+
+    var data interface{}
+    var err error
+    status := http.StatusOK
+    switch {
+    case path == "nodes" && r.Method == http.MethodGet:
+        nodes := server.store.ListNodes([]string{})
+        sortNodesByName(nodes)
+        data = nodes
+    case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "view" && r.Method == http.MethodGet:
+        data, err = server.viewNode(parts[1], r.URL.Query().Get("anchor"))
+    case path == "highlight" && r.Method == http.MethodPost:
+        data, err = server.highlight(r)
+    case path == "nodes" && r.Method == http.MethodPost:
+        data, err = server.addNode(r)
+        status = http.StatusCreated
+    case len(parts) == 2 && parts[0] == "nodes" && r.Method == http.MethodGet:
+        data, err = server.store.GetById(parts[1])
+    case len(parts) == 2 && parts[0] == "nodes" && r.Method == http.MethodPatch:
+        data, err = server.patchNode(parts[1], r)
+    case len(parts) == 2 && parts[0] == "nodes" && r.Method == http.MethodDelete:
+        if _, err = server.store.GetById(parts[1]); err == nil {
+            err = server.store.Remove(parts[1])
+            data = map[string]string{"Removed": parts[1]}
+        }
+    case path == "search" && r.Method == http.MethodGet:
+        category := r.URL.Query().Get("category")
+        keywords := server.store.ReplaceAlias(strings.Fields(r.URL.Query().Get("q")))
+        nodes := withoutSecrets(server.store.Search(category, keywords))
+        data = SearchResult{keywords, category, len(nodes), nodes}
+    case path == "categories" && r.Method == http.MethodGet:
+        data = server.store.ListCategories()
+    case path == "aliases" && r.Method == http.MethodGet:
+        data = server.store.GetAlias()
+    case path == "stats" && r.Method == http.MethodGet:
+        data = server.store.GetStats()
+    default:
+        err = newCodedError(ERR_NOT_FOUND, "no route for " + r.Method + " " + r.URL.Path)
+    }
+
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJson(w, status, data)
+}
+
+// setCorsHeaders allows the request origin when it is configured, it
+// returns false for requests that are not cross origin.
+func (server *apiServer) setCorsHeaders(w http.ResponseWriter, r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return false
+    }
+    for _, allowed := range server.options.CorsOrigins {
+        if allowed == "*" || allowed == origin {
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+            w.Header().Add("Vary", "Origin")
+            return true
+        }
+    }
+    return false
+}
+
+// checkOrigin keeps other sites out: Host must name this server, which
+// stops dns rebinding, and a browser Origin must be this server or a
+// configured cors origin, which stops forms and fetches of other pages.
+func (server *apiServer) checkOrigin(r *http.Request) error {
+    if !server.allowedHost(r.Host) {
+        return newCodedError(ERR_FORBIDDEN, "host " + r.Host + " is not allowed")
+    }
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return nil
+    }
+    for _, allowed := range server.options.CorsOrigins {
+        if allowed == "*" || allowed == origin {
+            return nil
+        }
+    }
+    if u, err := url.Parse(origin); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host == r.Host {
+        return nil
+    }
+    return newCodedError(ERR_FORBIDDEN, "origin " + origin + " is not allowed")
+}
+
+// allowedHost takes the listen address, ip addresses and localhost on the
+// listen port, and the hosts of the cors origins. Other names may point
+// here by dns rebinding.
+func (server *apiServer) allowedHost(host string) bool {
+    if host == server.options.Addr || host == uiHost(server.options.Addr) {
+        return true
+    }
+    for _, allowed := range server.options.CorsOrigins {
+        if u, err := url.Parse(allowed); err == nil && u.Host != "" && u.Host == host {
+            return true
+        }
+    }
+    name, port, err := net.SplitHostPort(host)
+    if err != nil {
+        name, port = host, ""
+    }
+    _, listenPort, _ := net.SplitHostPort(server.options.Addr)
+    if port != listenPort {
+        return false
+    }
+    return name == "localhost" || net.ParseIP(strings.Trim(name, "[]")) != nil
+}
+
+func (server *apiServer) authorize(r *http.Request) error {
+    if server.options.Token == "" {
+        return nil
+    }
+    token := ""
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        token = strings.TrimPrefix(auth, "Bearer ")
+    }
+    if subtle.ConstantTimeCompare([]byte(token), []byte(server.options.Token)) != 1 {
+        return newCodedError(ERR_UNAUTHORIZED, "missing or wrong token")
+    }
+    return nil
+}
+
+// decodeBody only takes application/json, forms of other sites can send
+// text/plain without a preflight.
+func decodeBody(r *http.Request, v interface{}) error {
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if mediaType != "application/json" {
+        return newCodedError(ERR_INVALID, "body must be application/json")
+    }
+    err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize)).Decode(v)
+    if err != nil {
+        return newCodedError(ERR_INVALID, "invalid json body: " + err.Error())
+    }
+    return nil
+}
+
+func (server *apiServer) addNode(r *http.Request) (interface{}, error) {
+    node := Node{}
+    if err := decodeBody(r, &node); err != nil {
+        return nil, err
+    }
+    if node.Executable && node.ExecFile == "" {
+        return nil, newCodedError(ERR_INVALID, "executable node needs ExecFile")
+    }
+    if err := checkSecretWrite(node); err != nil {
+        return nil, err
+    }
+    id, err := server.add(node)
+    if err != nil {
+        return nil, err
+    }
+    return server.store.GetById(id)
+}
+
+// secretPatchFields are the fields a patch may change on a secret node,
+// the sealed content and how it runs stay with gaia edit.
+var secretPatchFields = []string{"Name", "Tags", "Desc", "Links"}
+
+// isPatchField compares like encoding/json, which matches keys ignoring case.
+func isPatchField(key string, fields ...string) bool {
+    for _, field := range fields {
+        if strings.EqualFold(key, field) {
+            return true
+        }
+    }
+    return false
+}
+
+// patchNode changes only the fields present in the body, Id and Category
+// belong to the store. Secret is set and cleared only by the cli, which
+// holds the passphrase.
+func (server *apiServer) patchNode(id string, r *http.Request) (interface{}, error) {
+    node, err := server.store.GetById(id)
+    if err != nil {
+        return nil, err
+    }
+    fields := map[string]json.RawMessage{}
+    if err := decodeBody(r, &fields); err != nil {
+        return nil, err
+    }
+    for key := range fields {
+        if isPatchField(key, "Id", "Category") {
+            return nil, newCodedError(ERR_INVALID, key + " can not be changed")
+        }
+        if isPatchField(key, "Secret") {
+            return nil, newCodedError(ERR_FORBIDDEN, "Secret can not be changed over the api")
+        }
+        if node.Secret && !isPatchField(key, secretPatchFields...) {
+            return nil, newCodedError(ERR_FORBIDDEN, "node " + id + " is secret, only " + strings.Join(secretPatchFields, ", ") + " can be changed")
+        }
+    }
+
+    bs, _ := json.Marshal(fields)
+    if err := json.Unmarshal(bs, &node); err != nil {
+        return nil, newCodedError(ERR_INVALID, "invalid json body: " + err.Error())
+    }
+    node.Id = id
+    if err := checkSecretWrite(node); err != nil {
+        return nil, err
+    }
+    if err := server.store.Update(node); err != nil {
+        return nil, err
+    }
+    return server.store.GetById(id)
+}
+
+// uiHost makes a listen address like :8080 browsable.
+func uiHost(addr string) string {
+    if strings.HasPrefix(addr, ":") {
+        return "localhost" + addr
+    }
+    return addr
+}
+
+func isLoopbackAddr(addr string) bool {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// Serve blocks until the server fails.
+func (op *Operator) Serve(options ServeOptions) {
+    if op.err != nil {
+        return
+    }
+    if options.Addr == "" {
+        op.err = newCodedError(ERR_USAGE, "address is empty")
+        return
+    }
+    if options.Token == "" && !(options.ReadOnly && isLoopbackAddr(options.Addr)) {
+        op.err = newCodedError(ERR_USAGE, "serve needs a token, set --token or $" + serveTokenEnv + ", only --read-only on a loopback address may go without")
+        return
+    }
+
+    if op.isText() {
+        mode := ""
+        if options.ReadOnly {
+            mode = " (read-only)"
+        }
+        fmt.Println("serving on " + options.Addr + mode)
+        if options.UI {
+            fmt.Println("web ui on http://" + uiHost(options.Addr) + "/")
+        }
+        if options.Token == "" {
+            fmt.Println("warning: no token set, any local user can read the items")
+        }
+    }
+    server := &http.Server{
+        Addr: options.Addr,
+        Handler: newApiServer(op, options),
+        ReadHeaderTimeout: serveReadHeaderTimeout,
+        ReadTimeout: serveReadTimeout,
+        WriteTimeout: serveWriteTimeout,
+        IdleTimeout: serveIdleTimeout,
+    }
+    err := server.ListenAndServe()
+    if !errors.Is(err, http.ErrServerClosed) {
+        op.err = err
+    }
+}
@@ -1,7 +1,7 @@
 package main
 
 type Store interface {
-    Add(node Node) error
+    Add(node Node) (string, error) // returns the generated id
     AddAlias(from, to string) error
     RemoveAlias(keyword string) error
     Update(node Node) error
@@ -9,12 +9,16 @@ type Store interface {
     Search(category string, keywords []string) []Node
     Remove(id string) error
     GetById(id string) (Node, error)
+    Touch(id string) error // counts a get or exec of the node
     GetStats() Stats
     GetAlias() map[string]string
     ListCategories() map[string][]string
     ListNodes(names []string) []Node
     ReplaceAlias(strArr []string) []string
+    GetCardStates() map[string]CardState // node id -> review schedule
+    SetCardState(id string, state CardState) error
     ReorgAllData() error
+    Fsck(repair bool) ([]FsckIssue, error) // checks the data, see fsck.go
     FormatData() error
 }
 
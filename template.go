@@ -0,0 +1,141 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// placeholders look like {{host}} or {{ns:default}}, a backslash in front
+// (\{{host}}) keeps one literal.
+var templateVarRegexp = regexp.MustCompile(`\\?\{\{([A-Za-z_][A-Za-z0-9_.-]*)(:[^{}\n]*)?\}\}`)
+
+type TemplateVar struct {
+    Name string
+    Default string
+    HasDefault bool
+}
+
+// parseTemplateVars lists the declared variables in order of appearance,
+// the first default given for a name wins.
+func parseTemplateVars(content string) []TemplateVar {
+    vars := []TemplateVar{}
+    indexMap := make(map[string]int)
+    for _, m := range templateVarRegexp.FindAllStringSubmatch(content, -1) {
+        if strings.HasPrefix(m[0], "\\") {
+            continue
+        }
+
+        v := TemplateVar{Name: m[1]}
+        if m[2] != "" {
+            v.Default = m[2][1:]
+            v.HasDefault = true
+        }
+
+        if i, exist := indexMap[v.Name]; exist {
+            if !vars[i].HasDefault && v.HasDefault {
+                vars[i] = v
+            }
+            continue
+        }
+        indexMap[v.Name] = len(vars)
+        vars = append(vars, v)
+    }
+    return vars
+}
+
+// renderTemplate fills placeholders from values, then defaults. Names with
+// neither are left as they are and returned sorted.
+func renderTemplate(content string, values map[string]string) (string, []string) {
+    defaults := make(map[string]string)
+    for _, v := range parseTemplateVars(content) {
+        if v.HasDefault {
+            defaults[v.Name] = v.Default
+        }
+    }
+
+    missingMap := make(map[string]bool)
+    rendered := templateVarRegexp.ReplaceAllStringFunc(content, func(placeholder string) string {
+        if strings.HasPrefix(placeholder, "\\") {
+            return placeholder[1:]
+        }
+
+        m := templateVarRegexp.FindStringSubmatch(placeholder)
+        if value, exist := values[m[1]]; exist {
+            return value
+        }
+        if value, exist := defaults[m[1]]; exist {
+            return value
+        }
+        missingMap[m[1]] = true
+        return placeholder
+    })
+
+    missing := []string{}
+    for name := range missingMap {
+        missing = append(missing, name)
+    }
+    sort.Strings(missing)
+    return rendered, missing
+}
+
+// missingVarsError fails a render that left variables without a value.
+func missingVarsError(missing []string) error {
+    if len(missing) == 0 {
+        return nil
+    }
+    return newCodedError(ERR_INVALID, "missing value for variables: " + strings.Join(missing, ", ") + ", set them with --set name=value")
+}
+
+// promptTemplateVars asks for every variable not in values, an empty answer
+// takes the default.
+func promptTemplateVars(vars []TemplateVar, values map[string]string, in io.Reader, out io.Writer) error {
+    reader := bufio.NewReader(in)
+    for _, v := range vars {
+        if _, exist := values[v.Name]; exist {
+            continue
+        }
+
+        if v.HasDefault {
+            fmt.Fprintf(out, "%s [%s]: ", v.Name, v.Default)
+        } else {
+            fmt.Fprintf(out, "%s: ", v.Name)
+        }
+        line, err := reader.ReadString('\n')
+        if err != nil && (err != io.EOF || line == "" && !v.HasDefault) {
+            return errors.New("missing value for variable: " + v.Name)
+        }
+
+        line = strings.TrimRight(line, "\r\n")
+        if line == "" && v.HasDefault {
+            line = v.Default
+        }
+        values[v.Name] = line
+    }
+    return nil
+}
+
+// varsFlag collects repeated --set name=value flags.
+type varsFlag map[string]string
+
+func (vf varsFlag) String() string {
+    parts := []string{}
+    for k, v := range vf {
+        parts = append(parts, k + "=" + v)
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ",")
+}
+
+func (vf varsFlag) Set(s string) error {
+    index := strings.Index(s, "=")
+    if index <= 0 {
+        return errors.New("expect name=value, got: " + s)
+    }
+    vf[strings.TrimSpace(s[:index])] = s[index + 1:]
+    return nil
+}
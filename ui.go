@@ -0,0 +1,186 @@
+package main
+
+import (
+    "bufio"
+    "embed"
+    "fmt"
+    "io"
+    "io/fs"
+    "net/http"
+    "os"
+    "os/exec"
+    "strings"
+    "time"
+)
+
+// the web ui of `gaia serve --ui` lives in ui/ and is built into the
+// binary. On top of the api it uses
+//   GET  /nodes/{id}/view        highlighted lines, anchors and vars
+//   POST /highlight              highlight unsaved content for the preview
+//   POST /nodes/{id}/run         runs the node with the RunRequest body,
+//                                output as server-sent events
+//go:embed ui
+var uiFiles embed.FS
+
+const uiPathPrefix = "ui/"
+
+// NodeView is what the viewer shows of a node, Lines are highlightCode
+// tokens of the content or of an anchor only.
+type NodeView struct {
+    Node Node
+    Anchor string
+    Language string
+    Lines [][]codeToken
+    Anchors []string
+    AnchorLines map[int]string // 0 based index in Lines -> anchor it starts
+    Vars []TemplateVar
+    Runnable bool
+}
+
+// serveUiFile serves index.html for / and the assets below /ui/, they are
+// public as they hold no data.
+func serveUiFile(w http.ResponseWriter, r *http.Request, path string) bool {
+    if r.Method != http.MethodGet || path != "" && !strings.HasPrefix(path, uiPathPrefix) {
+        return false
+    }
+    files, _ := fs.Sub(uiFiles, "ui")
+    if path == "" {
+        r.URL.Path = "/"
+    } else {
+        r.URL.Path = "/" + strings.TrimPrefix(path, uiPathPrefix)
+    }
+    http.FileServer(http.FS(files)).ServeHTTP(w, r)
+    return true
+}
+
+// RunRequest sets the template vars of a run, like `gaia exec --set`.
+type RunRequest struct {
+    Set []string // k=v
+}
+
+// canRun also wants a token, Serve refuses to start without one then.
+func (server *apiServer) canRun() bool {
+    return server.options.UI && !server.options.ReadOnly && server.options.Token != ""
+}
+
+func (server *apiServer) viewNode(id string, anchor string) (NodeView, error) {
+    node, err := server.store.GetById(id)
+    if err != nil {
+        return NodeView{}, err
+    }
+    content := node.Content
+    if anchor != "" {
+        content, err = addressContent(content, isProse(node), anchor, "")
+        if err != nil {
+            return NodeView{}, err
+        }
+    }
+    lang := nodeLanguage(node)
+    view := NodeView{
+        Node: node,
+        Anchor: anchor,
+        Language: lang,
+        Lines: highlightCode(content, lang),
+        Anchors: listAnchors(node.Content, isProse(node)),
+        Vars: parseTemplateVars(node.Content),
+        Runnable: node.Executable && server.canRun(),
+    }
+    if anchor == "" {
+        view.AnchorLines = anchorLines(node.Content, isProse(node))
+    }
+    return view, nil
+}
+
+func (server *apiServer) highlight(r *http.Request) (interface{}, error) {
+    node := Node{}
+    if err := decodeBody(r, &node); err != nil {
+        return nil, err
+    }
+    lang := nodeLanguage(node)
+    return NodeView{Node: node, Language: lang, Lines: highlightCode(node.Content, lang), Anchors: listAnchors(node.Content, isProse(node))}, nil
+}
+
+// runNode execs the node in a child gaia, so a failing run can not take
+// the server down, and streams its output line by line. The last event
+// is "exit" with the exit code.
+func (server *apiServer) runNode(w http.ResponseWriter, r *http.Request, id string) {
+    if !server.canRun() {
+        writeError(w, newCodedError(ERR_FORBIDDEN, "running nodes needs --ui, a token and no --read-only"))
+        return
+    }
+    request := RunRequest{}
+    if err := decodeBody(r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+    server.mu.Lock()
+    node, err := server.store.GetById(id)
+    server.mu.Unlock()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if !node.Executable {
+        writeError(w, newCodedError(ERR_INVALID, "node " + id + " is not executable"))
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        writeError(w, newCodedError(ERR_INTERNAL, "streaming is not supported"))
+        return
+    }
+    self, err := os.Executable()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    // the stream lasts as long as the run, the exec limits bound it.
+    if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    args := []string{"exec"}
+    for _, value := range request.Set {
+        args = append(args, "--set", value)
+    }
+    args = append(args, id)
+    cmd := exec.CommandContext(r.Context(), self, args...)
+    pr, pw := io.Pipe()
+    cmd.Stdout = pw
+    cmd.Stderr = pw
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+
+    done := make(chan int)
+    go func() {
+        code := 0
+        if err := cmd.Run(); err != nil {
+            code = -1
+            if exitErr, ok := err.(*exec.ExitError); ok {
+                code = exitErr.ExitCode()
+            } else {
+                fmt.Fprintln(pw, "error:", err)
+            }
+        }
+        pw.Close()
+        done <- code
+    }()
+
+    reader := bufio.NewReader(pr)
+    for {
+        line, err := reader.ReadString('\n')
+        if line != "" {
+            fmt.Fprintf(w, "data: %s\n\n", strings.TrimRight(line, "\r\n"))
+            flusher.Flush()
+        }
+        if err != nil {
+            break
+        }
+    }
+    fmt.Fprintf(w, "event: exit\ndata: %d\n\n", <-done)
+    flusher.Flush()
+}
@@ -0,0 +1,327 @@
+// the web ui of `gaia serve --ui`, it only talks to the json api.
+(function() {
+    var $ = function(id) { return document.getElementById(id); };
+    var current = null; // the NodeView on screen
+    var editing = null; // id of the edited node, "" for a new one
+    var runSource = null; // AbortController of the running node
+
+    function token() {
+        return localStorage.getItem("gaiaToken") || "";
+    }
+
+    function api(method, path, body) {
+        var headers = {"Content-Type": "application/json"};
+        if (token()) {
+            headers["Authorization"] = "Bearer " + token();
+        }
+        return fetch(path, {method: method, headers: headers, body: body ? JSON.stringify(body) : undefined})
+            .then(function(res) {
+                return res.json().then(function(data) {
+                    if (res.status === 401) {
+                        var t = prompt("token of this gaia server");
+                        if (t !== null) {
+                            localStorage.setItem("gaiaToken", t);
+                            return api(method, path, body);
+                        }
+                    }
+                    if (!res.ok) {
+                        throw new Error(data.Message || res.statusText);
+                    }
+                    return data;
+                });
+            });
+    }
+
+    function el(tag, text, className) {
+        var e = document.createElement(tag);
+        if (text !== undefined) {
+            e.textContent = text;
+        }
+        if (className) {
+            e.className = className;
+        }
+        return e;
+    }
+
+    // renderLines fills pre with highlighted tokens, the marker lines in
+    // anchorLines become links to their section.
+    function renderLines(pre, lines, anchorLines, onAnchor) {
+        pre.innerHTML = "";
+        lines.forEach(function(tokens, i) {
+            var anchor = anchorLines && anchorLines[i];
+            var line = anchor ? el("span", undefined, "link") : el("span");
+            if (anchor) {
+                line.title = "show " + anchor + " only";
+                line.onclick = function() { onAnchor(anchor); };
+            }
+            tokens.forEach(function(t) {
+                line.appendChild(t.Kind ? el("span", t.Text, t.Kind) : document.createTextNode(t.Text));
+            });
+            pre.appendChild(line);
+            if (i < lines.length - 1) {
+                pre.appendChild(document.createTextNode("\n"));
+            }
+        });
+    }
+
+    function show(section) {
+        ["viewer", "editor", "welcome"].forEach(function(id) {
+            $(id).hidden = id !== section;
+        });
+    }
+
+    function view(id, anchor) {
+        var path = "/nodes/" + encodeURIComponent(id) + "/view";
+        if (anchor) {
+            path += "?anchor=" + encodeURIComponent(anchor);
+        }
+        return api("GET", path).then(function(v) {
+            current = v;
+            location.hash = id + (anchor || "");
+            var node = v.Node;
+            $("view-name").textContent = node.Name;
+            var meta = "ID: " + node.Id;
+            if (node.Tags) {
+                meta += " · TAGS: " + node.Tags;
+            }
+            if (node.Executable) {
+                meta += " · FILE: " + node.ExecFile;
+            }
+            $("view-meta").textContent = meta;
+            $("view-desc").textContent = node.Desc;
+
+            var anchors = $("view-anchors");
+            anchors.innerHTML = "";
+            if (v.Anchors.length > 0) {
+                var all = el("span", "all", "anchor" + (anchor ? "" : " active"));
+                all.onclick = function() { view(id); };
+                anchors.appendChild(all);
+            }
+            v.Anchors.forEach(function(a) {
+                var chip = el("span", a, "anchor" + (a === anchor ? " active" : ""));
+                chip.onclick = function() { view(id, a); };
+                anchors.appendChild(chip);
+            });
+
+            renderLines($("view-code"), v.Lines, v.AnchorLines, function(a) { view(id, a); });
+            $("run-button").hidden = !v.Runnable;
+            $("run-output").hidden = true;
+            show("viewer");
+        }).catch(alert);
+    }
+
+    function buildTree(nodes) {
+        var root = {children: {}};
+        nodes.forEach(function(node) {
+            var cur = root;
+            node.Name.split("-").forEach(function(part) {
+                cur.children[part] = cur.children[part] || {children: {}};
+                cur = cur.children[part];
+            });
+            cur.node = node;
+        });
+        return root;
+    }
+
+    function renderTree(ul, tn) {
+        Object.keys(tn.children).sort().forEach(function(part) {
+            var child = tn.children[part];
+            var li = el("li");
+            var label = el("span", part, child.node ? "link" : "branch");
+            if (child.node) {
+                label.onclick = function() { view(child.node.Id); };
+            }
+            li.appendChild(label);
+            if (Object.keys(child.children).length > 0) {
+                var sub = el("ul");
+                if (!child.node) {
+                    label.textContent = part + "/";
+                    label.onclick = function() { sub.hidden = !sub.hidden; };
+                }
+                renderTree(sub, child);
+                li.appendChild(sub);
+            }
+            ul.appendChild(li);
+        });
+    }
+
+    function loadTree() {
+        return api("GET", "/nodes").then(function(nodes) {
+            $("tree").innerHTML = "";
+            renderTree($("tree"), buildTree(nodes));
+        }).catch(alert);
+    }
+
+    var searchTimer = null;
+    $("search").oninput = function() {
+        clearTimeout(searchTimer);
+        searchTimer = setTimeout(function() {
+            var q = $("search").value.trim();
+            $("tree").hidden = q !== "";
+            $("results").innerHTML = "";
+            if (q === "") {
+                return;
+            }
+            api("GET", "/search?q=" + encodeURIComponent(q)).then(function(res) {
+                res.Nodes.sort(function(a, b) { return a.Name < b.Name ? -1 : 1; }).forEach(function(node) {
+                    var li = el("li");
+                    var a = el("span", node.Name, "link");
+                    a.onclick = function() { view(node.Id); };
+                    li.appendChild(a);
+                    $("results").appendChild(li);
+                });
+                if (res.Total === 0) {
+                    $("results").appendChild(el("li", "nothing found", "meta"));
+                }
+            }).catch(alert);
+        }, 150);
+    };
+
+    function edit(node) {
+        editing = node.Id || "";
+        $("edit-name").value = node.Name || "";
+        $("edit-tags").value = node.Tags || "";
+        $("edit-desc").value = node.Desc || "";
+        $("edit-executable").checked = !!node.Executable;
+        $("edit-execfile").value = node.ExecFile || "";
+        $("edit-content").value = node.Content || "";
+        $("edit-error").textContent = "";
+        preview();
+        show("editor");
+    }
+
+    function editedNode() {
+        return {
+            Name: $("edit-name").value.trim(),
+            Tags: $("edit-tags").value.trim(),
+            Desc: $("edit-desc").value.trim(),
+            Executable: $("edit-executable").checked,
+            ExecFile: $("edit-execfile").value.trim(),
+            Content: $("edit-content").value
+        };
+    }
+
+    var previewTimer = null;
+    function preview() {
+        clearTimeout(previewTimer);
+        previewTimer = setTimeout(function() {
+            api("POST", "/highlight", editedNode()).then(function(v) {
+                renderLines($("edit-preview"), v.Lines);
+            }).catch(function() {});
+        }, 200);
+    }
+    ["edit-content", "edit-tags", "edit-execfile"].forEach(function(id) {
+        $(id).oninput = preview;
+    });
+
+    $("edit-button").onclick = function() { edit(current.Node); };
+    $("new-button").onclick = function() { edit({}); };
+    $("cancel-button").onclick = function() {
+        if (current) {
+            show("viewer");
+        } else {
+            show("welcome");
+        }
+    };
+    $("save-button").onclick = function() {
+        var saved = editing ? api("PATCH", "/nodes/" + encodeURIComponent(editing), editedNode()) : api("POST", "/nodes", editedNode());
+        saved.then(function(node) {
+            loadTree();
+            view(node.Id);
+        }).catch(function(err) {
+            $("edit-error").textContent = err.message;
+        });
+    };
+
+    // runs stream server-sent events from a POST, EventSource can only GET.
+    function readEvents(res, onEvent) {
+        var reader = res.body.getReader();
+        var decoder = new TextDecoder();
+        var buffer = "";
+        function pump() {
+            return reader.read().then(function(chunk) {
+                if (chunk.done) {
+                    return;
+                }
+                buffer += decoder.decode(chunk.value, {stream: true});
+                var i;
+                while ((i = buffer.indexOf("\n\n")) >= 0) {
+                    var event = "message", data = [];
+                    buffer.slice(0, i).split("\n").forEach(function(line) {
+                        if (line.indexOf("event: ") === 0) {
+                            event = line.slice(7);
+                        } else if (line.indexOf("data: ") === 0) {
+                            data.push(line.slice(6));
+                        }
+                    });
+                    buffer = buffer.slice(i + 2);
+                    onEvent(event, data.join("\n"));
+                }
+                return pump();
+            });
+        }
+        return pump();
+    }
+
+    function run(id, set) {
+        if (runSource) {
+            runSource.abort();
+        }
+        runSource = new AbortController();
+        var out = $("run-output");
+        out.textContent = "";
+        out.hidden = false;
+        var headers = {"Content-Type": "application/json"};
+        if (token()) {
+            headers["Authorization"] = "Bearer " + token();
+        }
+        fetch("/nodes/" + encodeURIComponent(id) + "/run", {method: "POST", headers: headers, body: JSON.stringify({Set: set}), signal: runSource.signal})
+            .then(function(res) {
+                if (!res.ok) {
+                    return res.json().then(function(data) {
+                        if (res.status === 401) {
+                            var t = prompt("token of this gaia server");
+                            if (t !== null) {
+                                localStorage.setItem("gaiaToken", t);
+                                return run(id, set);
+                            }
+                        }
+                        throw new Error(data.Message || res.statusText);
+                    });
+                }
+                return readEvents(res, function(event, data) {
+                    if (event === "exit") {
+                        out.textContent += "[exit " + data + "]\n";
+                    } else {
+                        out.textContent += data + "\n";
+                    }
+                    out.scrollTop = out.scrollHeight;
+                });
+            }).catch(function(err) {
+                if (err.name !== "AbortError") {
+                    out.textContent += "error: " + err.message + "\n";
+                }
+            });
+    }
+
+    $("run-button").onclick = function() {
+        var set = [];
+        for (var i = 0; i < current.Vars.length; i++) {
+            var v = current.Vars[i];
+            var value = prompt(v.Name, v.Default);
+            if (value === null) {
+                return;
+            }
+            set.push(v.Name + "=" + value);
+        }
+        run(current.Node.Id, set);
+    };
+
+    loadTree().then(function() {
+        var hash = decodeURIComponent(location.hash.slice(1));
+        if (hash) {
+            var i = hash.indexOf("#");
+            view(i > 0 ? hash.slice(0, i) : hash, i > 0 ? hash.slice(i) : "");
+        }
+    });
+})();
@@ -0,0 +1,187 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os/exec"
+    "os/user"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The store stamps Created and Updated on add, update and append, and
+// counts get and exec in LastAccessed and AccessCount, see Store.Touch.
+// Nodes from before these fields have zero times, they count as unknown:
+// list --recent leaves them out and list --stale takes them as unused.
+const nodeTimeLayout = "2006-01-02 15:04"
+
+// NodeUsage is kept out of data.json in usage.json next to it, so get
+// does not rewrite the data file, and out of the commits of GitStore.
+type NodeUsage struct {
+    LastAccessed time.Time
+    AccessCount int
+}
+
+const usageFile = "usage.json"
+
+// nodeUsage is the usage of the nodes that were used, by id.
+func nodeUsage(data *GaiaData) map[string]NodeUsage {
+    usage := make(map[string]NodeUsage)
+    for id, node := range data.NodeMap {
+        if node.AccessCount > 0 {
+            usage[id] = NodeUsage{node.LastAccessed, node.AccessCount}
+        }
+    }
+    return usage
+}
+
+// readNodeUsage reads a usage file, a missing or broken one is empty.
+func readNodeUsage(path string) map[string]NodeUsage {
+    usage := map[string]NodeUsage{}
+    if bs, err := ioutil.ReadFile(path); err == nil {
+        json.Unmarshal(bs, &usage)
+    }
+    return usage
+}
+
+func writeNodeUsage(path string, data *GaiaData) error {
+    bs, _ := json.MarshalIndent(nodeUsage(data), "", "  ")
+    return ioutil.WriteFile(path, bs, 0660)
+}
+
+// applyNodeUsage sets the usage of the nodes in usage, others keep theirs.
+func applyNodeUsage(data *GaiaData, usage map[string]NodeUsage) {
+    for id, u := range usage {
+        if node, exist := data.NodeMap[id]; exist {
+            node.LastAccessed, node.AccessCount = u.LastAccessed, u.AccessCount
+            data.NodeMap[id] = node
+        }
+    }
+}
+
+// NodeActivity is a row of list --recent and list --stale.
+type NodeActivity struct {
+    Id string
+    Name string
+    Author string `json:",omitempty"`
+    Created *time.Time `json:",omitempty"` // nil when unknown
+    Updated *time.Time `json:",omitempty"`
+    LastAccessed *time.Time `json:",omitempty"`
+    AccessCount int
+}
+
+func nodeActivity(node Node) NodeActivity {
+    return NodeActivity{node.Id, node.Name, node.Author, optionalTime(node.Created), optionalTime(node.Updated), optionalTime(node.LastAccessed), node.AccessCount}
+}
+
+// lastChanged is when the node was added or updated, lastUsed also counts
+// get and exec.
+func lastChanged(node Node) time.Time {
+    if node.Updated.After(node.Created) {
+        return node.Updated
+    }
+    return node.Created
+}
+
+func lastUsed(node Node) time.Time {
+    if node.LastAccessed.After(lastChanged(node)) {
+        return node.LastAccessed
+    }
+    return lastChanged(node)
+}
+
+// parseAge reads the age of list --stale: 180d, 2w, or go durations like 12h.
+func parseAge(s string) (time.Duration, error) {
+    s = strings.ToLower(strings.TrimSpace(s))
+    units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+    invalid := newCodedError(ERR_USAGE, "bad age " + s + ", expect e.g. 180d, 2w or 12h")
+    if s == "" {
+        return 0, invalid
+    }
+    if unit, ok := units[s[len(s) - 1:]]; ok {
+        n, err := strconv.Atoi(s[:len(s) - 1])
+        if err != nil || n < 0 {
+            return 0, invalid
+        }
+        return time.Duration(n) * unit, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+        return 0, invalid
+    }
+    return d, nil
+}
+
+// recentNodes are the nodes with a known time, last changed first.
+func recentNodes(nodes []Node) []Node {
+    res := []Node{}
+    for _, node := range nodes {
+        if !lastChanged(node).IsZero() {
+            res = append(res, node)
+        }
+    }
+    sort.SliceStable(res, func(i, j int) bool {
+        return lastChanged(res[i]).After(lastChanged(res[j]))
+    })
+    return res
+}
+
+// staleNodes are the nodes not used since before, least recently used first.
+func staleNodes(nodes []Node, before time.Time) []Node {
+    res := []Node{}
+    for _, node := range nodes {
+        if lastUsed(node).Before(before) {
+            res = append(res, node)
+        }
+    }
+    sort.SliceStable(res, func(i, j int) bool {
+        if !lastUsed(res[i]).Equal(lastUsed(res[j])) {
+            return lastUsed(res[i]).Before(lastUsed(res[j]))
+        }
+        return res[i].Name < res[j].Name
+    })
+    return res
+}
+
+const (
+    SORT_USAGE = "usage"
+    SORT_RECENT = "recent"
+)
+
+// sortNodes orders search results, the most used or the last changed
+// first. An empty by keeps the order.
+func sortNodes(nodes []Node, by string) error {
+    switch by {
+    case "":
+    case SORT_USAGE:
+        sort.SliceStable(nodes, func(i, j int) bool {
+            if nodes[i].AccessCount != nodes[j].AccessCount {
+                return nodes[i].AccessCount > nodes[j].AccessCount
+            }
+            return nodes[i].LastAccessed.After(nodes[j].LastAccessed)
+        })
+    case SORT_RECENT:
+        sort.SliceStable(nodes, func(i, j int) bool {
+            return lastChanged(nodes[i]).After(lastChanged(nodes[j]))
+        })
+    default:
+        return newCodedError(ERR_USAGE, "unknown sort " + by + ", expect " + SORT_USAGE + " or " + SORT_RECENT)
+    }
+    return nil
+}
+
+// nodeAuthor is Author of the config, else the git user, else the login.
+func nodeAuthor(configured string) string {
+    if configured != "" {
+        return configured
+    }
+    if out, err := exec.Command("git", "config", "user.name").Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+        return strings.TrimSpace(string(out))
+    }
+    if usr, err := user.Current(); err == nil {
+        return usr.Username
+    }
+    return ""
+}